@@ -1,15 +1,18 @@
 package Config
 
 import (
-	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
-	"os"
+	"net"
+	"reflect"
+	"strings"
 )
 
 type Args struct {
 	ConfigFile string
 	JustTemplate bool
+	Overrides map[string]string // settings set on the command line, by path
 }
 
 type Config struct {
@@ -20,7 +23,119 @@ type Config struct {
 	ReCaptcha *ReCaptcha
 	SiteName string `json:"SiteName"`
 	TagLine string `json:"TagLine"`
+	Theme string `json:"Theme"` // Directory whose files override the built in website
+	Language string `json:"Language"` // Catalog used when Accept-Language names none of the available ones
 	Port int `json:"Port"`
+	Hosts []string `json:"Hosts"` // Extra hostnames gShort accepts requests for
+	TrustedProxies []string `json:"TrustedProxies"` // CIDRs or IPs of proxies allowed to set X-Forwarded-* and Forwarded
+	APIKeys []string `json:"APIKeys"`
+	ForwardQuery bool `json:"ForwardQuery"` // add the query string of visits to the URL of links, links can override it
+	Redirect string `json:"Redirect"` // how links redirect unless they say otherwise, one of RedirectTypes
+	GeoIP string `json:"GeoIP"` // MaxMind DB file (.mmdb) with the country of IPs, for the geo rules of links
+	RateLimit *RateLimit
+	Domains []*Domain // Extra short domains served by this instance
+	TLS *TLS
+	Server *Server
+	AdminListen string `json:"AdminListen"` // Address like 127.0.0.1:9090 for admin endpoints, they are served on the main router when empty
+	Metrics bool `json:"Metrics"` // Serve Prometheus metrics on /metrics
+	Log *Log
+	Tracing *Tracing
+	Security *Security
+	CORS *CORS
+	QRCode *QRCode
+	ReloadInterval int `json:"ReloadInterval"` // seconds between checks of the config file for changes, 0 only reloads on SIGHUP
+
+	trustedProxies []*net.IPNet
+	sites          []*Config
+}
+
+// A short domain served next to the main one. Unset settings are taken from the main config.
+// Slugs are unique per domain: each domain uses its own Collection or, by default,
+// its own Namespace in the main collection
+type Domain struct {
+	Domain   string   `json:"Domain"`
+	Hosts    []string `json:"Hosts"`
+	SiteName string   `json:"SiteName"`
+	TagLine  string   `json:"TagLine"`
+	Theme    string   `json:"Theme"`
+	Language string   `json:"Language"`
+	RandomStringGenerator *RandomStringGenerator
+	ReCaptcha *ReCaptcha
+	QRCode *QRCode // unset settings are taken from the main QRCode
+	Collection string `json:"Collection"`
+	Namespace  string `json:"Namespace"`
+}
+
+// Security headers sent with every response
+type Security struct {
+	ContentSecurityPolicy string   `json:"ContentSecurityPolicy"` // replaces the built in policy, {nonce} is replaced by the nonce of the request, "-" sends none
+	ExtraSources          []string `json:"ExtraSources"`          // added to script-src, style-src, img-src and connect-src of the built in policy
+	FrameAncestors        []string `json:"FrameAncestors"`        // who can frame the pages, defaults to 'none'
+	ReferrerPolicy        string   `json:"ReferrerPolicy"`        // defaults to strict-origin-when-cross-origin
+	HSTSMaxAge            int      `json:"HSTSMaxAge"`            // seconds, sent on HTTPS responses, 0 disables HSTS
+	HSTSIncludeSubDomains bool     `json:"HSTSIncludeSubDomains"`
+	HSTSPreload           bool     `json:"HSTSPreload"`
+}
+
+// Cross-origin requests allowed to the API and the pages
+type CORS struct {
+	AllowedOrigins   []string `json:"AllowedOrigins"`   // eg: https://app.example, "*" allows any. Defaults to the origins of the domain
+	AllowedHeaders   []string `json:"AllowedHeaders"`   // defaults to Content-Type, Key and X-API-Key
+	AllowCredentials bool     `json:"AllowCredentials"` // not allowed with "*"
+	MaxAge           int      `json:"MaxAge"`           // seconds browsers may cache a preflight, defaults to 600
+}
+
+// QR codes of the short links, served on /{slug}.png and /{slug}.svg
+type QRCode struct {
+	Size       int    `json:"Size"`       // pixels, defaults to 256. Requests can ask for another one with ?size=
+	MaxSize    int    `json:"MaxSize"`    // largest ?size= allowed, defaults to 1024
+	Level      string `json:"Level"`      // error correction: L, M (default), Q or H. Codes with a Logo use at least Q
+	Foreground string `json:"Foreground"` // colour of the dark modules like #000000
+	Background string `json:"Background"` // colour of the light modules like #ffffff
+	Logo       string `json:"Logo"`       // PNG, JPEG or GIF drawn in the center, a file of the theme or a path
+}
+
+type Log struct {
+	Format string `json:"Format"` // "logfmt" (default) or "json"
+	Level  string `json:"Level"`  // "debug", "info" (default), "warn" or "error"
+}
+
+// OpenTelemetry tracing, spans are sent to an OTLP/HTTP collector. Tracing is off without Endpoint
+type Tracing struct {
+	Endpoint    string            `json:"Endpoint"`    // eg: http://localhost:4318
+	Headers     map[string]string `json:"Headers"`     // sent with every export, eg: for authentication
+	ServiceName string            `json:"ServiceName"` // defaults to gshort
+	SampleRatio float64           `json:"SampleRatio"` // fraction of new traces recorded, defaults to 1
+}
+
+// HTTP server limits, zero values use the defaults below
+type Server struct {
+	ReadTimeout       int   `json:"ReadTimeout"`       // seconds, defaults to 10
+	ReadHeaderTimeout int   `json:"ReadHeaderTimeout"` // seconds, defaults to 5
+	WriteTimeout      int   `json:"WriteTimeout"`      // seconds, defaults to 30
+	IdleTimeout       int   `json:"IdleTimeout"`       // seconds, defaults to 120
+	ShutdownTimeout   int   `json:"ShutdownTimeout"`   // seconds to drain requests on shutdown, defaults to 30
+	ReadyTimeout      int   `json:"ReadyTimeout"`      // seconds /readyz waits for the database, defaults to 2
+	MaxHeaderBytes    int   `json:"MaxHeaderBytes"`    // defaults to 64KiB
+	MaxBodyBytes      int64 `json:"MaxBodyBytes"`      // defaults to 64KiB
+	MaxBulkBytes      int64 `json:"MaxBulkBytes"`      // bodies of /api/links/bulk, defaults to 4MiB
+	MaxBulkRows       int   `json:"MaxBulkRows"`       // rows of /api/links/bulk, defaults to 1000
+}
+
+// Serve HTTPS directly, certificates are picked by SNI and reloaded when their files change
+type TLS struct {
+	Port           int  `json:"Port"`           // HTTPS port, defaults to 443
+	RedirectHTTP   bool `json:"RedirectHTTP"`   // the HTTP listener on Port only redirects to HTTPS
+	ReloadInterval int  `json:"ReloadInterval"` // seconds between checks for new certificates, defaults to 60
+	HSTSMaxAge     int  `json:"HSTSMaxAge"`     // seconds, 0 disables HSTS. Deprecated, see Security
+	HSTSIncludeSubDomains bool `json:"HSTSIncludeSubDomains"`
+	HSTSPreload           bool `json:"HSTSPreload"`
+	Certificates []*Certificate
+}
+
+type Certificate struct {
+	CertFile string `json:"CertFile"`
+	KeyFile  string `json:"KeyFile"`
 }
 
 type ReCaptcha struct {
@@ -32,6 +147,25 @@ type MongoDB struct {
 	URI        string `json:"URI"`
 	DataBase   string `json:"DataBase"`
 	Collection string `json:"Collection"`
+	Namespace  string `json:"Namespace"` // Records of other namespaces in the collection are ignored
+}
+
+// Rate limits are applied per client, a client being either an API key (X-API-Key header)
+// or the client IP
+type RateLimit struct {
+	Backend    string `json:"Backend"`    // "memory" (default) or "mongodb" to share limits between replicas
+	Collection string `json:"Collection"` // MongoDB collection used by the "mongodb" backend
+	Create   *RateLimitPolicy
+	Redirect *RateLimitPolicy
+	Password *RateLimitPolicy
+}
+
+// Allows Requests every Period seconds with bursts of up to Burst requests.
+// A nil policy or one with Requests == 0 disables the limit
+type RateLimitPolicy struct {
+	Requests int `json:"Requests"`
+	Period   int `json:"Period"`
+	Burst    int `json:"Burst"`
 }
 
 type RandomStringGenerator struct {
@@ -40,52 +174,200 @@ type RandomStringGenerator struct {
 }
 
 func ParseArgs() *Args {
-	a := &Args{}
-	flag.StringVar(&a.ConfigFile, "config", "", "JSON Config File")
+	a := NewArgs(flag.CommandLine)
 	flag.BoolVar(&a.JustTemplate, "templateonly", false, "Create a _template directory with the templated HTMLs")
 	flag.Parse()
 	return a
 }
 
-func (*Config) LoadConfigFrom(file string) (config *Config, err error) {
-	ConfigFileByteArr, err := ioutil.ReadFile(file)
-	if err != nil {
-		return
+// Registers -config and the override flag of every setting on fs, they are set by fs.Parse.
+// Admin commands use it to take the same flags as the server
+func NewArgs(fs *flag.FlagSet) *Args {
+	a := &Args{Overrides: map[string]string{}}
+	fs.StringVar(&a.ConfigFile, "config", "", "Config File, JSON, YAML (.yaml/.yml) or TOML (.toml)")
+	for _, s := range settings() {
+		fs.Var(&overrideFlag{path: s.Path, overrides: a.Overrides, isBool: s.Type.Kind() == reflect.Bool},
+			s.Flag(), "Overrides "+s.Path+", also set by $"+s.Env())
+	}
+	return a
+}
+
+// Loads the config: defaults, then the file (if any), then the environment and then overrides,
+// usually the command line flags. The result is validated and every problem found is returned at once
+func (*Config) LoadConfigFrom(file string, overrides map[string]string) (config *Config, err error) {
+	config = Default()
+	if file != "" {
+		ConfigFileByteArr, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = decode(file, ConfigFileByteArr, config); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
 	}
-	if err = json.Unmarshal(ConfigFileByteArr, &config); err != nil {
-		return
+	if err = config.override(envOverrides()); err != nil {
+		return nil, fmt.Errorf("environment: %v", err)
 	}
-	config.checkENV()
-	return
+	if err = config.override(overrides); err != nil {
+		return nil, fmt.Errorf("command line: %v", err)
+	}
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+	if err = config.parseTrustedProxies(); err != nil {
+		return nil, err
+	}
+	config.buildSites()
+	return config, nil
+}
+
+// Builds the config of every domain, see Sites
+// Domains must have been validated
+func (config *Config) buildSites() {
+	config.sites = []*Config{config}
+	for _, d := range config.Domains {
+		site := *config
+		site.Domain = d.Domain
+		site.Hosts = d.Hosts
+		site.Domains = nil
+		site.sites = nil
+		if d.SiteName != "" {
+			site.SiteName = d.SiteName
+		}
+		if d.TagLine != "" {
+			site.TagLine = d.TagLine
+		}
+		if d.Theme != "" {
+			site.Theme = d.Theme
+		}
+		if d.Language != "" {
+			site.Language = d.Language
+		}
+		if d.RandomStringGenerator != nil {
+			site.RandomStringGenerator = d.RandomStringGenerator
+		}
+		if d.ReCaptcha != nil {
+			site.ReCaptcha = d.ReCaptcha
+		}
+		if d.QRCode != nil {
+			site.QRCode = d.QRCode.inherit(config.QRCode)
+		}
+
+		db := *config.MongoDB
+		db.Namespace = d.Namespace
+		if d.Collection != "" {
+			db.Collection = d.Collection
+		} else if db.Namespace == "" {
+			db.Namespace = d.Domain
+		}
+		site.MongoDB = &db
+
+		config.sites = append(config.sites, &site)
+	}
+}
+
+// A copy of q with its unset settings taken from parent
+func (q *QRCode) inherit(parent *QRCode) *QRCode {
+	if q == nil || parent == nil {
+		return q
+	}
+	c := *q
+	if c.Size == 0 {
+		c.Size = parent.Size
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = parent.MaxSize
+	}
+	if c.Level == "" {
+		c.Level = parent.Level
+	}
+	if c.Foreground == "" {
+		c.Foreground = parent.Foreground
+	}
+	if c.Background == "" {
+		c.Background = parent.Background
+	}
+	if c.Logo == "" {
+		c.Logo = parent.Logo
+	}
+	return &c
 }
 
-// Override some settings using ENV (heroku)
-func (config *Config) checkENV () *Config {
-	// TODO REWORK THIS CRAP
-	i := os.Getenv("ReCaptcha_SiteKey") // heroku
-	if i != "" { // if env exists
-		config.ReCaptcha.SiteKey = i
+// The port users reach gShort on, the HTTPS port when serving TLS over https
+func (config *Config) PublicPort() int {
+	if config.TLS != nil && config.Protocol == "https" {
+		return config.TLS.HTTPSPort()
 	}
+	return config.Port
+}
 
-	i = os.Getenv("ReCaptcha_SecretKey") // heroku
-	if i != "" { // if env exists
-		config.ReCaptcha.SecretKey = i
+func (t *TLS) HTTPSPort() int {
+	if t.Port == 0 {
+		return 443
 	}
+	return t.Port
+}
 
-	i = os.Getenv("MongoDB_URI") // heroku
-	if i != "" { // if env exists
-		config.MongoDB.URI = i
+// Returns one config per served domain with the settings of that domain, the main config comes first
+func (config *Config) Sites() []*Config {
+	if config.sites == nil {
+		return []*Config{config}
 	}
+	return config.sites
+}
 
-	i = os.Getenv("MongoDB_DataBase") // heroku
-	if i != "" { // if env exists
-		config.MongoDB.DataBase = i
+// Returns the config of the served domain named domain, the main one when domain is empty.
+// nil when the domain isn't served
+func (config *Config) Site(domain string) *Config {
+	if domain == "" {
+		return config
+	}
+	for _, s := range config.Sites() {
+		if strings.EqualFold(s.Domain, domain) {
+			return s
+		}
 	}
+	return nil
+}
 
-	i = os.Getenv("MongoDB_Collection") // heroku
-	if i != "" { // if env exists
-		config.MongoDB.Collection = i
+// Parses TrustedProxies, entries can be CIDRs or single IPs
+func (config *Config) parseTrustedProxies() error {
+	config.trustedProxies = nil
+	for _, p := range config.TrustedProxies {
+		n, err := parseProxy(p)
+		if err != nil {
+			return err
+		}
+		config.trustedProxies = append(config.trustedProxies, n)
 	}
+	return nil
+}
 
-	return config
-}
\ No newline at end of file
+func parseProxy(p string) (*net.IPNet, error) {
+	if !strings.Contains(p, "/") {
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", p)
+		}
+		if ip.To4() != nil {
+			p += "/32"
+		} else {
+			p += "/128"
+		}
+	}
+	_, n, err := net.ParseCIDR(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+	}
+	return n, nil
+}
+
+// Whether ip belongs to one of the TrustedProxies
+func (config *Config) IsTrustedProxy(ip net.IP) bool {
+	for _, n := range config.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
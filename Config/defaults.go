@@ -0,0 +1,40 @@
+package Config
+
+// Settings used when neither the config file, the environment nor the command line set them.
+// Domain has no default, everything else is enough to run against a local MongoDB
+func Default() *Config {
+	return &Config{
+		MongoDB: &MongoDB{
+			URI:        "mongodb://localhost:27017",
+			DataBase:   "gshort",
+			Collection: "maps",
+		},
+		RandomStringGenerator: &RandomStringGenerator{
+			Length:  7,
+			Charset: "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+		},
+		Protocol:  "http",
+		ReCaptcha: &ReCaptcha{},
+		SiteName:  "gShort",
+		TagLine:   "A URL shortener that just works",
+		Port:      8080,
+		Language:  "en",
+		Redirect:  "302",
+		Log: &Log{
+			Format: "logfmt",
+			Level:  "info",
+		},
+		Security: &Security{
+			FrameAncestors: []string{"'none'"},
+			ReferrerPolicy: "strict-origin-when-cross-origin",
+		},
+		QRCode: &QRCode{
+			Size:       256,
+			MaxSize:    1024,
+			Level:      "M",
+			Foreground: "#000000",
+			Background: "#ffffff",
+		},
+		ReloadInterval: 5,
+	}
+}
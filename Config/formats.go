@@ -0,0 +1,57 @@
+package Config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Decodes a config file over config, the format is picked from the file extension
+// (.yaml/.yml, .toml, anything else is JSON). Keys are matched case insensitively in every format
+func decode(file string, data []byte, config *Config) error {
+	var (
+		raw interface{}
+		err error
+	)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+		raw = fromYAML(raw)
+	case ".toml":
+		m := map[string]interface{}{}
+		_, err = toml.Decode(string(data), &m)
+		raw = m
+	default:
+		return json.Unmarshal(data, config)
+	}
+	if err != nil {
+		return err
+	}
+	// Going through JSON keeps a single set of decoding rules for every format
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, config)
+}
+
+// YAML mappings decode to map[interface{}]interface{}, which JSON can't encode
+func fromYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = fromYAML(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = fromYAML(e)
+		}
+	}
+	return v
+}
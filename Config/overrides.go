@@ -0,0 +1,198 @@
+package Config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every setting can be overridden from the environment and the command line. A setting
+// is addressed by its path in the config, eg: MongoDB.URI, which becomes the
+// GSHORT_MONGODB_URI variable and the -mongodb.uri flag.
+// Lists take comma separated values, maps comma separated key=value pairs and
+// lists of objects (Domains, TLS.Certificates) JSON
+const envPrefix = "GSHORT_"
+
+// Variables gShort read before the systematic names existed, still honoured (heroku)
+var legacyEnv = map[string]string{
+	"ReCaptcha_SiteKey":   "ReCaptcha.SiteKey",
+	"ReCaptcha_SecretKey": "ReCaptcha.SecretKey",
+	"MongoDB_URI":         "MongoDB.URI",
+	"MongoDB_DataBase":    "MongoDB.DataBase",
+	"MongoDB_Collection":  "MongoDB.Collection",
+}
+
+// A setting that can be overridden
+type setting struct {
+	Path string // eg: RateLimit.Create.Requests
+	Type reflect.Type
+}
+
+func (s setting) Env() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.Path, ".", "_", -1))
+}
+
+func (s setting) Flag() string {
+	return strings.ToLower(s.Path)
+}
+
+// Lists every setting of Config, nested objects are walked into
+func settings() []setting {
+	var all []setting
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" { // unexported
+				continue
+			}
+			path := prefix + f.Name
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				walk(ft.Elem(), path+".")
+				continue
+			}
+			all = append(all, setting{Path: path, Type: ft})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+	return all
+}
+
+// Collects the overrides set in the environment, legacy names first so the systematic ones win
+func envOverrides() map[string]string {
+	overrides := map[string]string{}
+	for name, path := range legacyEnv {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			overrides[path] = v
+		}
+	}
+	for _, s := range settings() {
+		if v, ok := os.LookupEnv(s.Env()); ok {
+			overrides[s.Path] = v
+		}
+	}
+	return overrides
+}
+
+// Applies overrides, keyed by setting path, to config
+func (config *Config) override(overrides map[string]string) error {
+	paths := make([]string, 0, len(overrides))
+	for path := range overrides {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := set(reflect.ValueOf(config).Elem(), strings.Split(path, "."), overrides[path]); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Sets the field at path below v, allocating the objects on the way
+func set(v reflect.Value, path []string, value string) error {
+	f := v.FieldByName(path[0])
+	if !f.IsValid() {
+		return fmt.Errorf("unknown setting")
+	}
+	if len(path) == 1 {
+		return parseInto(f, value)
+	}
+	if f.Kind() != reflect.Ptr || f.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unknown setting")
+	}
+	if f.IsNil() {
+		f.Set(reflect.New(f.Type().Elem()))
+	}
+	return set(f.Elem(), path[1:], value)
+}
+
+func parseInto(f reflect.Value, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.String {
+			return jsonInto(f, value)
+		}
+		var list []string
+		for _, e := range strings.Split(value, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, e)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := map[string]string{}
+		for _, e := range strings.Split(value, ",") {
+			if strings.TrimSpace(e) == "" {
+				continue
+			}
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("%q is not a key=value pair", e)
+			}
+			m[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+		f.Set(reflect.ValueOf(m))
+	default:
+		return jsonInto(f, value)
+	}
+	return nil
+}
+
+func jsonInto(f reflect.Value, value string) error {
+	p := reflect.New(f.Type())
+	if err := json.Unmarshal([]byte(value), p.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	f.Set(p.Elem())
+	return nil
+}
+
+// A flag.Value that stores what it is set to as an override
+type overrideFlag struct {
+	path      string
+	overrides map[string]string
+	isBool    bool
+}
+
+func (o *overrideFlag) String() string {
+	if o == nil || o.overrides == nil {
+		return ""
+	}
+	return o.overrides[o.path]
+}
+
+func (o *overrideFlag) Set(value string) error {
+	o.overrides[o.path] = value
+	return nil
+}
+
+func (o *overrideFlag) IsBoolFlag() bool {
+	return o.isBool
+}
@@ -0,0 +1,262 @@
+package Config
+
+import (
+	"fmt"
+	qrcode "gShort/QRCode"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Every problem found in a config, so they can all be fixed at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// How links can send visitors to their URL: a redirect with one of these status codes,
+// a page that redirects with a meta refresh or an interstitial page the visitor continues from
+var RedirectTypes = []string{"301", "302", "307", "308", "refresh", "interstitial"}
+
+// Whether s is one of RedirectTypes
+func ValidRedirect(s string) bool {
+	for _, t := range RedirectTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the whole config and reports every problem found, nil when there is none
+func (config *Config) Validate() error {
+	var problems []string
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	port := func(name string, p int) {
+		if p < 1 || p > 65535 {
+			add("%s must be between 1 and 65535, got %d", name, p)
+		}
+	}
+
+	if config.Domain == "" {
+		add("Domain is required")
+	}
+	if config.Protocol != "http" && config.Protocol != "https" {
+		add("Protocol must be http or https, got %q", config.Protocol)
+	}
+	port("Port", config.Port)
+	if config.MongoDB == nil {
+		add("MongoDB is required")
+	} else {
+		if !strings.HasPrefix(config.MongoDB.URI, "mongodb://") && !strings.HasPrefix(config.MongoDB.URI, "mongodb+srv://") {
+			add("MongoDB.URI must start with mongodb:// or mongodb+srv://")
+		}
+		if config.MongoDB.DataBase == "" {
+			add("MongoDB.DataBase is required")
+		}
+		if config.MongoDB.Collection == "" {
+			add("MongoDB.Collection is required")
+		}
+	}
+	generator := func(name string, g *RandomStringGenerator) {
+		if g.Length < 1 {
+			add("%s.Length must be at least 1, got %d", name, g.Length)
+		}
+		if g.Charset == "" {
+			add("%s.Charset is required", name)
+		}
+	}
+	if config.RandomStringGenerator == nil {
+		add("RandomStringGenerator is required")
+	} else {
+		generator("RandomStringGenerator", config.RandomStringGenerator)
+	}
+	theme := func(name string, dir string) {
+		if dir == "" {
+			return
+		}
+		if info, err := os.Stat(dir); err != nil {
+			add("%s: %v", name, err)
+		} else if !info.IsDir() {
+			add("%s: %s is not a directory", name, dir)
+		}
+	}
+	theme("Theme", config.Theme)
+	if config.GeoIP != "" {
+		if info, err := os.Stat(config.GeoIP); err != nil {
+			add("GeoIP: %v", err)
+		} else if info.IsDir() {
+			add("GeoIP: %s is a directory", config.GeoIP)
+		}
+	}
+	if config.ReCaptcha == nil {
+		add("ReCaptcha is required, it can be empty")
+	}
+	for _, p := range config.TrustedProxies {
+		if _, err := parseProxy(p); err != nil {
+			add("TrustedProxies: %v", err)
+		}
+	}
+	for i, k := range config.APIKeys {
+		if k == "" {
+			add("APIKeys[%d] is empty", i)
+		}
+	}
+	if !ValidRedirect(config.Redirect) {
+		add("Redirect must be one of %s, got %q", strings.Join(RedirectTypes, ", "), config.Redirect)
+	}
+	if config.ReloadInterval < 0 {
+		add("ReloadInterval can't be negative")
+	}
+	if config.AdminListen != "" {
+		if _, _, err := net.SplitHostPort(config.AdminListen); err != nil {
+			add("AdminListen: %v", err)
+		}
+	}
+
+	qr := func(name string, q *QRCode) {
+		if q == nil {
+			return
+		}
+		if q.Size < 21 || q.MaxSize < q.Size {
+			add("%s.Size must be at least 21 and no more than %s.MaxSize, got %d and %d", name, name, q.Size, q.MaxSize)
+		}
+		if _, err := qrcode.ParseLevel(q.Level); err != nil {
+			add("%s.Level: %v", name, err)
+		}
+		for _, c := range []string{q.Foreground, q.Background} {
+			if _, err := qrcode.ParseColor(c); err != nil {
+				add("%s: %v", name, err)
+			}
+		}
+	}
+	qr("QRCode", config.QRCode)
+
+	seen := map[string]bool{strings.ToLower(config.Domain): true}
+	for i, d := range config.Domains {
+		if d == nil || d.Domain == "" {
+			add("Domains[%d].Domain is required", i)
+			continue
+		}
+		if seen[strings.ToLower(d.Domain)] {
+			add("Domains[%d]: domain %q is configured twice", i, d.Domain)
+		}
+		seen[strings.ToLower(d.Domain)] = true
+		theme(fmt.Sprintf("Domains[%d].Theme", i), d.Theme)
+		if d.RandomStringGenerator != nil {
+			generator(fmt.Sprintf("Domains[%d].RandomStringGenerator", i), d.RandomStringGenerator)
+		}
+		qr(fmt.Sprintf("Domains[%d].QRCode", i), d.QRCode.inherit(config.QRCode))
+	}
+
+	if l := config.Log; l != nil {
+		switch l.Format {
+		case "", "logfmt", "json":
+		default:
+			add("Log.Format must be logfmt or json, got %q", l.Format)
+		}
+		switch strings.ToLower(l.Level) {
+		case "", "debug", "info", "warn", "error":
+		default:
+			add("Log.Level must be debug, info, warn or error, got %q", l.Level)
+		}
+	}
+
+	if t := config.Tracing; t != nil {
+		if t.Endpoint != "" {
+			u, err := url.Parse(t.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("Tracing.Endpoint must be an http(s) URL, got %q", t.Endpoint)
+			}
+		}
+		if t.SampleRatio < 0 || t.SampleRatio > 1 {
+			add("Tracing.SampleRatio must be between 0 and 1, got %v", t.SampleRatio)
+		}
+	}
+
+	if s := config.Server; s != nil {
+		limits := []struct {
+			name  string
+			value int64
+		}{
+			{"ReadTimeout", int64(s.ReadTimeout)}, {"ReadHeaderTimeout", int64(s.ReadHeaderTimeout)},
+			{"WriteTimeout", int64(s.WriteTimeout)}, {"IdleTimeout", int64(s.IdleTimeout)},
+			{"ShutdownTimeout", int64(s.ShutdownTimeout)}, {"ReadyTimeout", int64(s.ReadyTimeout)},
+			{"MaxHeaderBytes", int64(s.MaxHeaderBytes)}, {"MaxBodyBytes", s.MaxBodyBytes},
+			{"MaxBulkBytes", s.MaxBulkBytes}, {"MaxBulkRows", int64(s.MaxBulkRows)},
+		}
+		for _, l := range limits {
+			if l.value < 0 {
+				add("Server.%s can't be negative", l.name)
+			}
+		}
+	}
+
+	if t := config.TLS; t != nil {
+		if t.Port != 0 {
+			port("TLS.Port", t.Port)
+		}
+		if t.HTTPSPort() == config.Port {
+			add("TLS.Port and Port can't be the same, got %d", config.Port)
+		}
+		if t.RedirectHTTP && config.Protocol != "https" {
+			add("TLS.RedirectHTTP requires Protocol to be https")
+		}
+		if t.ReloadInterval < 0 || t.HSTSMaxAge < 0 {
+			add("TLS.ReloadInterval and TLS.HSTSMaxAge can't be negative")
+		}
+		if len(t.Certificates) == 0 {
+			add("TLS.Certificates is required when TLS is enabled")
+		}
+		for i, c := range t.Certificates {
+			if c == nil || c.CertFile == "" || c.KeyFile == "" {
+				add("TLS.Certificates[%d] needs a CertFile and a KeyFile", i)
+			}
+		}
+	}
+
+	if sec := config.Security; sec != nil && sec.HSTSMaxAge < 0 {
+		add("Security.HSTSMaxAge can't be negative")
+	}
+	if c := config.CORS; c != nil {
+		for _, o := range c.AllowedOrigins {
+			if o == "*" {
+				if c.AllowCredentials {
+					add("CORS.AllowCredentials can't be used with the \"*\" origin")
+				}
+				continue
+			}
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				add("CORS.AllowedOrigins: %q is not an origin like https://app.example", o)
+			}
+		}
+		if c.MaxAge < 0 {
+			add("CORS.MaxAge can't be negative")
+		}
+	}
+
+	if rl := config.RateLimit; rl != nil {
+		switch rl.Backend {
+		case "", "memory", "mongodb":
+		default:
+			add("RateLimit.Backend must be memory or mongodb, got %q", rl.Backend)
+		}
+		for i, p := range []*RateLimitPolicy{rl.Create, rl.Redirect, rl.Password} {
+			if p != nil && (p.Requests < 0 || p.Period < 0 || p.Burst < 0) {
+				add("RateLimit.%s can't have negative values", []string{"Create", "Redirect", "Password"}[i])
+			}
+		}
+	}
+
+	if problems == nil {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
@@ -0,0 +1,132 @@
+package DataBase
+
+import (
+	"context"
+	"gShort/Config"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A visit to a link that was sent on to its URL. Clicks are kept in the collection of
+// the links with a _clicks suffix, in the namespace of the links
+type Click struct {
+	Mapping   string    `json:"mapping"`
+	Namespace string    `json:"namespace"`
+	Time      time.Time `json:"time"`
+	Rule      string    `json:"rule"` // name of the Rule that picked the URL, geo:ES or geo:fallback for Geo, empty for the Url or Split of the link
+	OS        string    `json:"os"`   // what UserAgent.Parse made of the visitor, empty when unknown
+	Device    string    `json:"device"`
+	Browser   string    `json:"browser"`
+	Country   string    `json:"country"` // ISO 3166-1 code, empty when unknown or without a GeoIP database
+	Variant   string    `json:"variant"` // name of the Variant of the Split that picked the URL, empty for other URLs
+}
+
+// Click collections whose index was created, by database and name
+var clickIndexes sync.Map
+
+// The collection of the clicks, its {namespace, mapping} index is created the first time.
+// An index that can't be created is tried again the next time
+func clicks(ctx context.Context, client *mongo.Client, a *Config.MongoDB) *mongo.Collection {
+	col := client.Database(a.DataBase).Collection(a.Collection + "_clicks")
+	key := a.DataBase + "." + col.Name()
+	if _, done := clickIndexes.LoadOrStore(key, true); !done {
+		index := mongo.IndexModel{Keys: bson.D{{"namespace", 1}, {"mapping", 1}}}
+		if _, err := col.Indexes().CreateOne(ctx, index); err != nil {
+			clickIndexes.Delete(key)
+		}
+	}
+	return col
+}
+
+// Stores a Click in the configured namespace, Time is set to now when it's zero
+func InsertClick(ctx context.Context, a *Config.MongoDB, c *Click) (err error) {
+	ctx, done := observe(ctx, a, "insert_click")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
+	if err != nil {
+		return
+	}
+	cb := *c
+	cb.Namespace = a.Namespace
+	if cb.Time.IsZero() {
+		cb.Time = time.Now().UTC()
+	}
+	_, err = clicks(ctx, client, a).InsertOne(ctx, cb)
+	return
+}
+
+// Calls fn with every Click of the configured namespace, oldest first, until fn returns an error
+func EachClick(ctx context.Context, a *Config.MongoDB, fn func(c *Click) error) (err error) {
+	ctx, done := observe(ctx, a, "find_all_clicks")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
+	if err != nil {
+		return
+	}
+	cursor, err := clicks(ctx, client, a).Find(ctx, scoped(a, bson.D{}), options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		c := &Click{}
+		if err = cursor.Decode(c); err != nil {
+			return
+		}
+		if err = fn(c); err != nil {
+			return
+		}
+	}
+	err = cursor.Err()
+	return
+}
+
+// Counts the clicks of a mapping by the value they have in each of fields, eg: "rule" or "os", in one go.
+// Clicks recorded before a field existed are counted under ""
+func CountClicks(ctx context.Context, a *Config.MongoDB, mapping string, fields []string) (counts map[string]map[string]int, err error) {
+	ctx, done := observe(ctx, a, "count_clicks")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
+	if err != nil {
+		return
+	}
+	facets := bson.D{}
+	for _, field := range fields {
+		facets = append(facets, bson.E{Key: field, Value: bson.A{
+			bson.D{{"$group", bson.D{{"_id", bson.D{{"$ifNull", bson.A{"$" + field, ""}}}}, {"count", bson.D{{"$sum", 1}}}}}},
+		}})
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", scoped(a, bson.D{{"mapping", mapping}})}},
+		{{"$facet", facets}},
+	}
+	cursor, err := clicks(ctx, client, a).Aggregate(ctx, pipeline)
+	if err != nil {
+		return
+	}
+	defer cursor.Close(ctx)
+	var result map[string][]struct {
+		Value string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err = cursor.Decode(&result); err != nil {
+			return
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return
+	}
+	counts = make(map[string]map[string]int)
+	for _, field := range fields {
+		counts[field] = make(map[string]int)
+		for _, group := range result[field] {
+			counts[field][group.Value] += group.Count
+		}
+	}
+	return
+}
@@ -3,6 +3,11 @@ package DataBase
 import (
 	"context"
 	"gShort/Config"
+	"gShort/Metrics"
+	"gShort/Tracing"
+	"sync"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -14,138 +19,371 @@ type Record struct {
 	Password string `json:"url"`
 	HitCount int `json:"hitcount"`
 	MaxHitCount int `json:"maxhitcount"`
+	Namespace string `json:"namespace"`
+	ExpiresAt time.Time `json:"expiresat"` // zero when the link never expires
+	Disabled bool `json:"disabled"`
+	CreatedAt time.Time `json:"createdat"` // zero for links created before it was recorded
+	Prefix bool `json:"prefix"` // the path and query after the slug are appended to Url, see destination
+	ForwardQuery *bool `json:"forwardquery"` // whether the query of visits is added to Url, nil follows Config.ForwardQuery
+	UTM *UTM `json:"utm"` // UTM parameters added to Url on visits
+	Redirect string `json:"redirect"` // one of Config.RedirectTypes, empty follows Config.Redirect
+	Rules []Rule `json:"rules"` // the first one matching a visit picks its URL, Url is the default
+	Geo *Geo `json:"geo"` // picks the URL of the visits no Rule matched by their country
+	Split *Split `json:"split"` // picks the URL of the visits no Rule or Geo did among its variants, instead of Url
+	Alias bool `json:"alias"` // the mapping was chosen by whoever created the link, false for links created before it was recorded
+	Turns int `json:"turns"` // turns the round robin of Split took, see NextTurn
+}
+
+// Shares the visits of a link between variants, A/B tests or rotations
+type Split struct {
+	Variants []Variant `json:"variants"`
+	Rotation string    `json:"rotation"` // weighted picks variants at random by weight, roundrobin in turns by weight
+	Sticky   bool      `json:"sticky"`   // a cookie keeps visitors on the variant they got first
+}
+// A destination of a Split
+type Variant struct {
+	Name   string `json:"name"` // recorded with the clicks it gets
+	Url    string `json:"url"`
+	Weight *int   `json:"weight"` // share of the visits, 1 when left out. 0 pauses the variant
+}
+
+// Destinations by the country of the visitor
+type Geo struct {
+	Countries map[string]string `json:"countries"` // URL by ISO 3166-1 country code, like ES
+	Fallback  string            `json:"fallback"`  // for visitors of other countries or of an unknown one, empty for Url
 }
 
-// Boilerplate to connect to MongoDB and return a client and collection ready to use
-// TODO: Create 1 and reuse ?
+// Sends the visits it matches to Url. Every condition that is set must match,
+// a condition matches when the visit has any of its values
+type Rule struct {
+	Name     string   `json:"name"`     // recorded with the clicks it picks, defaults to its position from 1
+	OS       []string `json:"os"`       // see UserAgent.OSes
+	Device   []string `json:"device"`   // see UserAgent.Devices
+	Browser  []string `json:"browser"`  // see UserAgent.Browsers
+	Language []string `json:"language"` // tags like es or pt-BR, matched against the language the visitor prefers
+	Url      string   `json:"url"`
+}
+
+// UTM parameters of a link, empty ones are not added
+type UTM struct {
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+}
+
+// Whether the query string of visits is passed on to Url. Prefix links do it unless they say otherwise
+func (r *Record) ForwardsQuery(global bool) bool {
+	if r.ForwardQuery != nil {
+		return *r.ForwardQuery
+	}
+	return r.Prefix || global
+}
+
+// Whether the Record is a plain link: a random mapping of Url without password, limits, expiry or options.
+// Only plain links are handed out again for the same URL, see FilterFromURL
+func (r *Record) Plain() bool {
+	return !r.Alias && r.Password == "" && r.MaxHitCount == 0 && r.ExpiresAt.IsZero() && !r.Prefix && r.ForwardQuery == nil &&
+		r.UTM == nil && r.Redirect == "" && len(r.Rules) == 0 && r.Geo == nil && r.Split == nil
+}
+
+// Whether the link has expired at the given time
+func (r *Record) Expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// Starts the span of an operation, the returned func ends it and records how long the
+// operation took and whether it failed. Not finding a document is not a failure
+func observe(ctx context.Context, a *Config.MongoDB, operation string) (context.Context, func(err *error)) {
+	start := time.Now()
+	ctx, span := Tracing.Start(ctx, "mongodb "+operation, Tracing.KindClient)
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.name", a.DataBase)
+	span.SetAttribute("db.operation", operation)
+	return ctx, func(err *error) {
+		failed := *err != nil && *err != mongo.ErrNoDocuments
+		if failed {
+			span.SetError(*err)
+		}
+		span.End()
+		Metrics.StoreOperation(operation, time.Since(start), failed)
+	}
+}
+
+// Restricts a filter to the records of the configured namespace.
+// Records created before namespaces existed have none and belong to the default one
+func scoped(a *Config.MongoDB, filter bson.D) bson.D {
+	if a.Namespace == "" {
+		return append(filter, bson.E{Key: "namespace", Value: bson.D{{"$in", bson.A{nil, ""}}}})
+	}
+	return append(filter, bson.E{Key: "namespace", Value: a.Namespace})
+}
+
+// Clients by URI. A client keeps a pool of connections and is shared by every operation
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*mongo.Client)
+)
+
+// Returns the client of the configured URI and the configured collection, ready to use.
+// The client connects in the background the first time, operations wait for it
 // TODO: All functions should return a record instead
-func newClient(a *Config.MongoDB) (client *mongo.Client, collection *mongo.Collection, err error) {
-	clientOptions := options.Client().ApplyURI(a.URI)
-	client, err = mongo.Connect(context.TODO(), clientOptions)
-	if err != nil {
-		return
+func newClient(ctx context.Context, a *Config.MongoDB) (client *mongo.Client, collection *mongo.Collection, err error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	client, ok := clients[a.URI]
+	if !ok {
+		client, err = mongo.Connect(ctx, options.Client().ApplyURI(a.URI))
+		if err != nil {
+			return
+		}
+		clients[a.URI] = client
+	}
+	collection = client.Database(a.DataBase).Collection(a.Collection)
+	return
+}
+
+// Disconnects the clients, operations that are still running fail
+func Close(ctx context.Context) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for uri, client := range clients {
+		_ = client.Disconnect(ctx)
+		delete(clients, uri)
 	}
-	err = client.Ping(context.TODO(), nil)
+}
+
+// Checks that the database is reachable, ctx bounds how long it can take
+func Ping(ctx context.Context, a *Config.MongoDB) (err error) {
+	ctx, done := observe(ctx, a, "ping")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	collection = client.Database(a.DataBase).Collection(a.Collection)
+	err = client.Ping(ctx, nil)
 	return
 }
 
 // Create a new mapping in the DB
-func Insert(a *Config.MongoDB, url string, mapping string, password string, maxhitcount int) (result *mongo.InsertOneResult, err error) {
-	client, collection, err := newClient(a)
+func Insert(ctx context.Context, a *Config.MongoDB, url string, mapping string, password string, maxhitcount int) (result *mongo.InsertOneResult, err error) {
+	return InsertRecord(ctx, a, &Record{Url:url, Mapping:mapping, Password:password, MaxHitCount:maxhitcount})
+}
+
+// Stores a new Record in the configured namespace, CreatedAt is set to now when it's zero
+func InsertRecord(ctx context.Context, a *Config.MongoDB, r *Record) (result *mongo.InsertOneResult, err error) {
+	ctx, done := observe(ctx, a, "insert")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	rb := Record{Url:url, Mapping:mapping, Password:password, HitCount:0, MaxHitCount:maxhitcount}
-	result, err = collection.InsertOne(context.TODO(), rb)
+	rb := *r
+	rb.Namespace = a.Namespace
+	if rb.CreatedAt.IsZero() {
+		rb.CreatedAt = time.Now().UTC()
+	}
+	result, err = collection.InsertOne(ctx, rb)
+	return
+}
+
+// Looks for the Record of a Mapping, mongo.ErrNoDocuments when there is none
+func FindByMapping(ctx context.Context, a *Config.MongoDB, mapping string) (r *Record, err error) {
+	ctx, done := observe(ctx, a, "find_by_mapping")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	err = client.Disconnect(context.TODO())
+	r = &Record{}
+	err = collection.FindOne(ctx, scoped(a, bson.D{{"mapping", mapping}})).Decode(r)
 	if err != nil {
-		return
+		r = nil
 	}
 	return
 }
 
-// Looks for the URL using a Mapping
-func FilterFromMapping(a *Config.MongoDB, mapping string) (result string, err error) {
-	client, collection, err := newClient(a)
+// Calls fn with every Record of the configured namespace, oldest first, until fn returns an error
+func Each(ctx context.Context, a *Config.MongoDB, fn func(r *Record) error) (err error) {
+	ctx, done := observe(ctx, a, "find_all")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
+	if err != nil {
+		return
+	}
+	cursor, err := collection.Find(ctx, scoped(a, bson.D{}), options.Find().SetSort(bson.D{{"_id", 1}}))
 	if err != nil {
 		return
 	}
-	r := Record{}
-	filter := bson.D{{"mapping", mapping}}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		r := &Record{}
+		if err = cursor.Decode(r); err != nil {
+			return
+		}
+		if err = fn(r); err != nil {
+			return
+		}
+	}
+	err = cursor.Err()
+	return
+}
 
-	err = collection.FindOne(context.TODO(), filter).Decode(&r)
+// Looks for the Mapping of a plain Record of a URL that can be handed out again, mongo.ErrNoDocuments when there is none
+func FilterFromURL(ctx context.Context, a *Config.MongoDB, url string) (result string, err error) {
+	ctx, done := observe(ctx, a, "find_by_url")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	err = client.Disconnect(context.TODO())
+	filter := scoped(a, bson.D{{"url", url}})
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{"_id", 1}}))
 	if err != nil {
 		return
 	}
-	result = r.Url
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		r := Record{}
+		if err = cursor.Decode(&r); err != nil {
+			return
+		}
+		if r.Plain() && !r.Disabled {
+			result = r.Mapping
+			return
+		}
+	}
+	if err = cursor.Err(); err == nil {
+		err = mongo.ErrNoDocuments
+	}
 	return
 }
 
-// Looks for the Mapping using a URL
-func FilterFromURL(a *Config.MongoDB, url string) (result string, err error) {
-	client, collection, err := newClient(a)
+//Increases hitcount of a mapping by 1
+func IncreaseHitCount(ctx context.Context, a *Config.MongoDB, mapping string) (r* Record, err error) {
+	ctx, done := observe(ctx, a, "increase_hitcount")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	r := Record{}
-	filter := bson.D{{"url", url}}
-
-	err = collection.FindOne(context.TODO(), filter).Decode(&r)
+	filter := scoped(a, bson.D{{"mapping", mapping}})
+	update := bson.D{
+		{"$inc", bson.D{
+			{"hitcount", 1},
+		}},
+	}
+	_, err = collection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return
 	}
-	err = client.Disconnect(context.TODO())
+
+	err = collection.FindOne(ctx, filter).Decode(&r)
+	return
+}
+
+// Takes a turn of the round robin of the Split of a mapping, returns how many turns it took before.
+// Turns are counted atomically, every visit gets its own
+func NextTurn(ctx context.Context, a *Config.MongoDB, mapping string) (turn int, err error) {
+	ctx, done := observe(ctx, a, "next_turn")
+	defer done(&err)
+	_, collection, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	result = r.Mapping
+	filter := scoped(a, bson.D{{"mapping", mapping}})
+	update := bson.D{{"$inc", bson.D{{"turns", 1}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.D{{"turns", 1}})
+	var r struct {
+		Turns int `bson:"turns"`
+	}
+	err = collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&r)
+	turn = r.Turns
 	return
 }
 
-func IsPasswordProtected(a *Config.MongoDB, mapping string) (b bool, password string, err error) {
-	client, collection, err := newClient(a)
+// Saves the settings of the Record, everything but its mapping, namespace and the hit count and turns which visits keep updating.
+// mongo.ErrNoDocuments when there is no Record with its mapping
+func (r* Record) Update(ctx context.Context, a *Config.MongoDB) (err error) {
+	ctx, done := observe(ctx, a, "update")
+	defer done(&err)
+	b, err := bson.Marshal(r)
 	if err != nil {
 		return
 	}
-	r := Record{}
-	filter := bson.D{{"mapping", mapping}}
-
-	err = collection.FindOne(context.TODO(), filter).Decode(&r)
-	err = client.Disconnect(context.TODO())
+	var set bson.M
+	if err = bson.Unmarshal(b, &set); err != nil {
+		return
+	}
+	for _, key := range []string{"mapping", "namespace", "hitcount", "turns"} {
+		delete(set, key)
+	}
+	_, col, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	if len(r.Password) > 0 {
-		b = true
-		password = r.Password
+	result, err := col.UpdateOne(ctx, scoped(a, bson.D{{"mapping", r.Mapping}}), bson.D{{"$set", set}})
+	if err == nil && result.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
 	}
 	return
 }
 
-//Increases hitcount of a mapping by 1
-func IncreaseHitCount(a *Config.MongoDB, mapping string) (r* Record, err error) {
-	client, collection, err := newClient(a)
+// Deletes the Record on the given collection
+func (r* Record) Delete(ctx context.Context, a *Config.MongoDB) (err error) {
+	ctx, done := observe(ctx, a, "delete")
+	defer done(&err)
+	_, col, err := newClient(ctx, a)
+	if err != nil {
+		return
+	}
+	_, err = col.DeleteOne(ctx, scoped(a, bson.D{{"mapping", r.Mapping}}))
+	return
+}
+
+// Increases by 1 the counter stored under key in the given collection and returns its new value.
+// New counters are created with expireAt so a TTL index (see EnsureExpireIndex) can clean them up
+func IncreaseCounter(ctx context.Context, a *Config.MongoDB, collection string, key string, expireAt time.Time) (count int, err error) {
+	ctx, done := observe(ctx, a, "increase_counter")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	filter := bson.D{{"mapping", mapping}}
+	col := client.Database(a.DataBase).Collection(collection)
+	filter := bson.D{{"_id", key}}
 	update := bson.D{
 		{"$inc", bson.D{
-			{"hitcount", 1},
+			{"count", 1},
+		}},
+		{"$setOnInsert", bson.D{
+			{"expireat", expireAt},
 		}},
 	}
-	_, err = collection.UpdateOne(context.TODO(), filter, update)
-	if err != nil {
-		return
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var r struct {
+		Count int `bson:"count"`
 	}
-
-	err = collection.FindOne(context.TODO(), filter).Decode(&r)
+	err = col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&r)
 	if err != nil {
 		return
 	}
-
-	err = client.Disconnect(context.TODO())
+	count = r.Count
 	return
 }
 
-// Deletes the Record on the given collection
-func (r* Record) Delete(a *Config.MongoDB) (err error) {
-	client, col, err := newClient(a)
+// Creates a TTL index on the expireat field of the given collection
+func EnsureExpireIndex(ctx context.Context, a *Config.MongoDB, collection string) (err error) {
+	ctx, done := observe(ctx, a, "ensure_index")
+	defer done(&err)
+	client, _, err := newClient(ctx, a)
 	if err != nil {
 		return
 	}
-	_, err = col.DeleteOne(context.TODO(), bson.D{{"mapping", r.Mapping}})
-	err = client.Disconnect(context.TODO())
+	col := client.Database(a.DataBase).Collection(collection)
+	index := mongo.IndexModel{
+		Keys:    bson.D{{"expireat", 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err = col.Indexes().CreateOne(ctx, index)
 	return
 }
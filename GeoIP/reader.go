@@ -0,0 +1,346 @@
+// Package GeoIP looks up the country of IPs in a MaxMind DB (.mmdb) file,
+// such as GeoLite2 Country or City, without any network access
+package GeoIP
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// Ends the search tree and data section, the metadata follows it
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+var errInvalid = errors.New("invalid MaxMind DB")
+
+// A MaxMind DB file, read into memory. It's safe for concurrent use
+type DB struct {
+	Type       string // database_type of the metadata, eg: GeoLite2-Country
+	buf        []byte
+	nodeCount  uint
+	recordSize uint // bits of each of the two records of a node: 24, 28 or 32
+	ipVersion  uint
+	data       decoder // the data section, after the search tree
+	ipv4Start  uint    // node of ::/96, where IPv4 lookups start in IPv6 trees
+}
+
+// Reads a MaxMind DB file
+func Open(path string) (*DB, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := New(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// Reads a MaxMind DB from its bytes
+func New(buf []byte) (*DB, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, errInvalid
+	}
+	start := i + len(metadataMarker)
+	meta, _, err := decoder{buf[start:]}.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errInvalid
+	}
+	db := &DB{buf: buf}
+	db.Type, _ = m["database_type"].(string)
+	db.nodeCount = uint(asUint(m["node_count"]))
+	db.recordSize = uint(asUint(m["record_size"]))
+	db.ipVersion = uint(asUint(m["ip_version"]))
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, errInvalid
+	}
+	db.data = decoder{buf[treeSize+16 : i]}
+
+	if db.ipVersion == 6 {
+		for bit := 0; bit < 96 && db.ipv4Start < db.nodeCount; bit++ {
+			if db.ipv4Start, err = db.record(db.ipv4Start, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return db, nil
+}
+
+// The ISO 3166-1 code of the country of ip, like ES. Empty when the database doesn't know it
+func (db *DB) Country(ip net.IP) (string, error) {
+	record, err := db.Lookup(ip)
+	if err != nil || record == nil {
+		return "", err
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok {
+				return code, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// The data of ip, nil when the database has none
+func (db *DB) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if ip = ip.To16(); ip == nil || db.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(ip)*8 && node < db.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		next, err := db.record(node, bit)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	if node == db.nodeCount { // no data
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errInvalid
+	}
+	offset := node - db.nodeCount - 16
+	value, _, err := db.data.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// The left (bit 0) or right (bit 1) record of a node of the search tree
+func (db *DB) record(node uint, bit uint) (uint, error) {
+	size := db.recordSize / 4 // bytes of a node
+	off := node * size
+	if off+size > uint(len(db.buf)) {
+		return 0, errInvalid
+	}
+	b := db.buf[off : off+size]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]), nil
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6]), nil
+	default:
+		b = b[bit*4:]
+		return uint(b[0])<<24 | uint(b[1])<<16 | uint(b[2])<<8 | uint(b[3]), nil
+	}
+}
+
+func asUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
+
+// Decodes the values of a data section, pointers are offsets into it
+type decoder struct {
+	buf []byte
+}
+
+// Data types of the MaxMind DB format
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// Most maps and arrays nested in one another, a loop of pointers would go on forever
+const maxDepth = 32
+
+// Decodes the value at off, returns it and the offset after it
+func (d decoder) decode(off uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errInvalid
+	}
+	kind, size, off, err := d.control(off)
+	if err != nil {
+		return nil, 0, err
+	}
+	if kind == typePointer {
+		value, _, err := d.decode(size, depth+1)
+		return value, off, err
+	}
+	if kind == typeBool {
+		return size != 0, off, nil
+	}
+	if kind == typeMap || kind == typeArray {
+		return d.container(kind, size, off, depth)
+	}
+	if off+size > uint(len(d.buf)) {
+		return nil, 0, errInvalid
+	}
+	b, next := d.buf[off:off+size], off+size
+	switch kind {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errInvalid
+		}
+		return math.Float64frombits(beUint(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errInvalid
+		}
+		return math.Float32frombits(uint32(beUint(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errInvalid
+		}
+		return beUint(b), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errInvalid
+		}
+		return int32(beUint(b)), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, errInvalid
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
+
+func (d decoder) container(kind int, size uint, off uint, depth int) (interface{}, uint, error) {
+	if size > uint(len(d.buf)) { // every entry takes a byte at least
+		return nil, 0, errInvalid
+	}
+	if kind == typeArray {
+		array := make([]interface{}, size)
+		for i := range array {
+			var err error
+			if array[i], off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return array, off, nil
+	}
+	m := make(map[string]interface{}, size)
+	for i := uint(0); i < size; i++ {
+		key, next, err := d.decode(off, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, 0, errInvalid
+		}
+		if m[k], off, err = d.decode(next, depth+1); err != nil {
+			return nil, 0, err
+		}
+	}
+	return m, off, nil
+}
+
+// Reads the control byte at off: the type of the value and its size, or where a pointer points to.
+// off is moved past the control bytes, to the value
+func (d decoder) control(off uint) (kind int, size uint, next uint, err error) {
+	read := func(n uint) ([]byte, bool) {
+		if off+n > uint(len(d.buf)) {
+			return nil, false
+		}
+		b := d.buf[off : off+n]
+		off += n
+		return b, true
+	}
+	b, ok := read(1)
+	if !ok {
+		return 0, 0, 0, errInvalid
+	}
+	ctrl := b[0]
+	kind = int(ctrl >> 5)
+	if kind == typePointer {
+		n := uint(ctrl>>3&3) + 1
+		b, ok := read(n)
+		if !ok {
+			return 0, 0, 0, errInvalid
+		}
+		v := uint(ctrl & 7)
+		switch n {
+		case 1:
+			size = v<<8 | uint(beUint(b))
+		case 2:
+			size = (v<<16 | uint(beUint(b))) + 2048
+		case 3:
+			size = (v<<24 | uint(beUint(b))) + 526336
+		default:
+			size = uint(beUint(b))
+		}
+		return kind, size, off, nil
+	}
+	if kind == typeExtended {
+		if b, ok = read(1); !ok {
+			return 0, 0, 0, errInvalid
+		}
+		kind = 7 + int(b[0])
+	}
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if b, ok = read(n); !ok {
+			return 0, 0, 0, errInvalid
+		}
+		size = []uint{29, 285, 65821}[n-1] + uint(beUint(b))
+	}
+	return kind, size, off, nil
+}
+
+// Big endian unsigned integer of up to 8 bytes
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
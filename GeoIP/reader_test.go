@@ -0,0 +1,155 @@
+package GeoIP
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The databases of testdata are written by testdata/write.py
+func TestCountry(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"81.2.3.4", "ES"},
+		{"81.255.255.255", "ES"},
+		{"80.255.255.255", ""},
+		{"8.8.8.8", "US"},
+		{"8.8.9.1", ""},
+		{"10.1.200.7", "DE"},
+		{"10.2.0.1", ""},
+		{"::ffff:81.2.3.4", "ES"},
+		{"2a01:e35::1", "FR"},
+		{"2a02::1", ""},
+		{"::1", ""},
+	}
+	for _, file := range []string{"test-country-24.mmdb", "test-country-28.mmdb", "test-country-32.mmdb", "test-country-ipv4.mmdb"} {
+		db, err := Open("testdata/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if db.Type != "Test-Country" {
+			t.Errorf("%s: type %q", file, db.Type)
+		}
+		for _, tt := range tests {
+			want := tt.want
+			if file == "test-country-ipv4.mmdb" && net.ParseIP(tt.ip).To4() == nil {
+				want = "" // IPv4 databases know no IPv6 address
+			}
+			got, err := db.Country(net.ParseIP(tt.ip))
+			if err != nil || got != want {
+				t.Errorf("%s: %s is %q, %v, want %q", file, tt.ip, got, err, want)
+			}
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	db, err := Open("testdata/test-country-24.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.Lookup(net.ParseIP("8.8.8.8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"continent": map[string]interface{}{"code": "EU", "names": map[string]interface{}{"en": "Europe"}},
+		"country":   map[string]interface{}{"iso_code": "US", "names": map[string]interface{}{"en": strings.Repeat("x", 40)}},
+		"location":  map[string]interface{}{"latitude": 40.5},
+	}
+	if !reflect.DeepEqual(record, want) {
+		t.Errorf("got %v, want %v", record, want)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	valid, err := Open("testdata/test-country-24.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	marker := bytes.LastIndex(valid.buf, metadataMarker)
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"no metadata", valid.buf[:marker]},
+		{"no search tree", append([]byte{0, 0, 0}, valid.buf[marker:]...)},
+		{"truncated metadata", valid.buf[:len(valid.buf)-10]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.buf); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+// Values encoded as the data section of https://maxmind.github.io/MaxMind-DB/ describes them
+func TestDecode(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	tests := []struct {
+		name string
+		buf  []byte
+		want interface{}
+	}{
+		{"string", []byte("\x45hello"), "hello"},
+		{"empty string", []byte{0x40}, ""},
+		{"string of 29 bytes", append([]byte{0x5d, 0x00}, strings.Repeat("a", 29)...), strings.Repeat("a", 29)},
+		{"string of 300 bytes", append([]byte{0x5e, 0x00, 0x0f}, long...), long},
+		{"double", []byte{0x68, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, 1.5},
+		{"bytes", []byte{0x82, 0xca, 0xfe}, []byte{0xca, 0xfe}},
+		{"uint16", []byte{0xa2, 0x01, 0x2c}, uint64(300)},
+		{"uint32 zero", []byte{0xc0}, uint64(0)},
+		{"uint32", []byte{0xc4, 0xff, 0xff, 0xff, 0xff}, uint64(1<<32 - 1)},
+		{"int32", []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xff}, int32(-1)},
+		{"uint64", []byte{0x08, 0x02, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, uint64(1<<64 - 1)},
+		{"uint128", []byte{0x03, 0x03, 0x01, 0x00, 0x00}, big.NewInt(1 << 16)},
+		{"bool true", []byte{0x01, 0x07}, true},
+		{"bool false", []byte{0x00, 0x07}, false},
+		{"float", []byte{0x04, 0x08, 0x3f, 0xc0, 0x00, 0x00}, float32(1.5)},
+		{"map", []byte("\xe1\x42en\x41x"), map[string]interface{}{"en": "x"}},
+		{"array", []byte{0x02, 0x04, 0xa1, 0x01, 0x41, 'b'}, []interface{}{uint64(1), "b"}},
+		{"pointer", []byte{0x20, 0x02, 0x43, 'a', 'b', 'c'}, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := decoder{tt.buf}.decode(0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"short string", []byte("\x45hell")},
+		{"short double", []byte{0x64, 0, 0, 0, 0}},
+		{"long int32", []byte{0x05, 0x01, 0, 0, 0, 0, 0}},
+		{"map key not a string", []byte{0xe1, 0xa1, 0x01, 0x41, 'x'}},
+		{"map larger than the data", []byte{0xfd, 0xff}},
+		{"pointer loop", []byte{0x20, 0x00}},
+		{"end marker", []byte{0x00, 0x06}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v, _, err := (decoder{tt.buf}).decode(0, 0); err == nil {
+				t.Errorf("no error, got %#v", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+"""Writes the MaxMind DB files of the GeoIP tests, following https://maxmind.github.io/MaxMind-DB/
+
+An IPv6 tree with each record size and an IPv4 one, with the networks of nets:
+81.0.0.0/8 ES, 8.8.8.0/24 US, 10.1.0.0/16 DE and 2a01::/16 FR. Run it in this directory.
+"""
+import struct, ipaddress, sys
+def ctrl(t, size):
+    out=b''
+    if t<=7: first=t<<5
+    else: first=0
+    if size<29: first|=size; extra=b''
+    elif size<285: first|=29; extra=bytes([size-29])
+    elif size<65821: first|=30; extra=struct.pack('>H',size-285)
+    else: first|=31; extra=struct.pack('>I',size-65821)[1:]
+    out=bytes([first])
+    if t>7: out+=bytes([t-7])
+    return out+extra
+def enc(v):
+    if isinstance(v,bool): return ctrl(14, 1 if v else 0)
+    if isinstance(v,str): b=v.encode(); return ctrl(2,len(b))+b
+    if isinstance(v,int):
+        b=v.to_bytes((v.bit_length()+7)//8,'big') if v else b''
+        return ctrl(6 if v<2**32 else 9,len(b))+b
+    if isinstance(v,float): return ctrl(3,8)+struct.pack('>d',v)
+    if isinstance(v,dict):
+        o=ctrl(7,len(v))
+        for k,x in v.items(): o+=enc(k)+(x if isinstance(x,bytes) else enc(x))
+        return o
+    if isinstance(v,list):
+        o=ctrl(11,len(v))
+        for x in v: o+=enc(x)
+        return o
+def pointer(off):
+    if off<2048: return bytes([0x20|(off>>8)]) + bytes([off&0xff])
+    off-=2048; return bytes([0x28|(off>>16)]) + struct.pack('>H',off&0xffff)
+
+def build(nets, record_size=24, ipver=6):
+    # data section
+    data=b''
+    # a shared "continent" value referenced by pointer
+    shared_off=len(data); data+=enc({"code":"EU","names":{"en":"Europe"}})
+    offs={}
+    for net,cc in nets:
+        if cc not in offs:
+            offs[cc]=len(data)
+            data+=enc({"continent":pointer(shared_off),"country":{"iso_code":cc,"names":{"en":"x"*40}},"location":{"latitude":40.5}})
+    # trie
+    nodes=[[None,None]]
+    bits = 128 if ipver==6 else 32
+    for net,cc in nets:
+        n=ipaddress.ip_network(net)
+        if ipver==6 and n.version==4:
+            addr=int(n.network_address); plen=96+n.prefixlen
+        else:
+            addr=int(n.network_address); plen=n.prefixlen
+        node=0
+        for i in range(plen):
+            b=(addr>>(bits-1-i))&1
+            if i==plen-1:
+                nodes[node][b]=('d',offs[cc])
+            else:
+                if nodes[node][b] is None or nodes[node][b][0]=='d':
+                    nodes.append([None,None]); nodes[node][b]=('n',len(nodes)-1)
+                node=nodes[node][b][1]
+    nc=len(nodes)
+    def val(r):
+        if r is None: return nc
+        if r[0]=='n': return r[1]
+        return nc+16+r[1]
+    tree=b''
+    for l,r in nodes:
+        a,b=val(l),val(r)
+        if record_size==24: tree+=a.to_bytes(3,'big')+b.to_bytes(3,'big')
+        elif record_size==28:
+            tree+=(a&0xffffff).to_bytes(3,'big')+bytes([((a>>24)<<4)|(b>>24)])+(b&0xffffff).to_bytes(3,'big')
+        else: tree+=a.to_bytes(4,'big')+b.to_bytes(4,'big')
+    meta={"node_count":nc,"record_size":record_size,"ip_version":ipver,"database_type":"Test-Country","binary_format_major_version":2,"binary_format_minor_version":0,"build_epoch":1700000000,"languages":["en"],"description":{"en":"test"}}
+    return tree+b'\0'*16+data+b'\xab\xcd\xefMaxMind.com'+enc(meta)
+
+nets=[("81.0.0.0/8","ES"),("8.8.8.0/24","US"),("2a01::/16","FR"),("10.1.0.0/16","DE")]
+for rs in (24,28,32):
+    open('test-country-%d.mmdb'%rs,'wb').write(build(nets,rs))
+open('test-country-ipv4.mmdb','wb').write(build([n for n in nets if ':' not in n[0]],24,4))
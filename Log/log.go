@@ -0,0 +1,203 @@
+package Log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{DebugLevel: "debug", InfoLevel: "info", WarnLevel: "warn", ErrorLevel: "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+const redacted = "[REDACTED]"
+
+// Values of keys containing any of these are never written
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "cookie", "apikey", "api_key"}
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stderr
+	format           = "logfmt"
+	level            = InfoLevel
+)
+
+// Sets the output format ("logfmt" or "json") and the minimum level ("debug", "info", "warn" or "error")
+func Setup(f string, l string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	switch f {
+	case "", "logfmt":
+		format = "logfmt"
+	case "json":
+		format = "json"
+	default:
+		return fmt.Errorf("unknown log format %q", f)
+	}
+	if l == "" {
+		level = InfoLevel
+		return nil
+	}
+	for lv, name := range levelNames {
+		if name == strings.ToLower(l) {
+			level = lv
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q", l)
+}
+
+// A Logger writes entries with its fields, given as key value pairs, attached
+type Logger struct {
+	fields []interface{}
+}
+
+var root = &Logger{}
+
+type contextKey struct{}
+
+// Returns a Logger that adds the given key value pairs to every entry
+func With(kv ...interface{}) *Logger {
+	return root.With(kv...)
+}
+
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	return &Logger{fields: append(fields, kv...)}
+}
+
+// Stores l in ctx, see FromContext
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// Returns the Logger stored in ctx or one without fields
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return root
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(DebugLevel, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(InfoLevel, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(WarnLevel, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(ErrorLevel, msg, kv) }
+
+// Logs at error level and exits
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.log(ErrorLevel, msg, kv)
+	os.Exit(1)
+}
+
+func Debug(msg string, kv ...interface{}) { root.log(DebugLevel, msg, kv) }
+func Info(msg string, kv ...interface{})  { root.log(InfoLevel, msg, kv) }
+func Warn(msg string, kv ...interface{})  { root.log(WarnLevel, msg, kv) }
+func Error(msg string, kv ...interface{}) { root.log(ErrorLevel, msg, kv) }
+func Fatal(msg string, kv ...interface{}) { root.Fatal(msg, kv...) }
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if key == "key" {
+		return true
+	}
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) log(lv Level, msg string, kv []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if lv < level {
+		return
+	}
+
+	keys := []string{"time", "level", "msg"}
+	values := []interface{}{time.Now().UTC().Format(time.RFC3339Nano), lv.String(), msg}
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		if isSensitive(key) {
+			value = redacted
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	var buf bytes.Buffer
+	if format == "json" {
+		writeJSON(&buf, keys, values)
+	} else {
+		writeLogfmt(&buf, keys, values)
+	}
+	buf.WriteByte('\n')
+	_, _ = out.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, keys []string, values []interface{}) {
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(values[i])
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(values[i]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, keys []string, values []interface{}) {
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '=' || r == '"' {
+				return '_'
+			}
+			return r
+		}, key))
+		buf.WriteByte('=')
+		v := fmt.Sprint(values[i])
+		if v == "" || strings.ContainsAny(v, " =\"\t\r\n\\") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+}
@@ -0,0 +1,83 @@
+package Metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// Outcomes of a short link visit
+const (
+	Found          = "found"
+	PasswordPrompt = "password_prompt"
+	Unauthorized   = "unauthorized"
+	NotFound       = "not_found"
+	Expired        = "expired"
+	Disabled       = "disabled"
+)
+
+var (
+	requests = newCounterVec("gshort_http_requests_total",
+		"HTTP requests by route and status code.", "route", "code")
+	requestDuration = newHistogramVec("gshort_http_request_duration_seconds",
+		"HTTP request latencies by route.", "route")
+	redirects = newCounterVec("gshort_redirects_total",
+		"Short link visits by outcome.", "outcome")
+	captchaFailures = newCounterVec("gshort_captcha_failures_total",
+		"Link creations rejected by reCAPTCHA.")
+	slugCollisions = newCounterVec("gshort_slug_collisions_total",
+		"Generated slugs that were already taken and had to be generated again.")
+	storeDuration = newHistogramVec("gshort_store_operation_duration_seconds",
+		"Database operation latencies by operation.", "operation")
+	storeErrors = newCounterVec("gshort_store_errors_total",
+		"Failed database operations by operation.", "operation")
+	configReloads = newCounterVec("gshort_config_reloads_total",
+		"Config reloads by result, failed reloads keep the previous config.", "result")
+)
+
+func init() {
+	// Export every outcome from the start so rates can be computed right away
+	for _, outcome := range []string{Found, PasswordPrompt, Unauthorized, NotFound, Expired, Disabled} {
+		redirects.add(0, outcome)
+	}
+	captchaFailures.add(0)
+	slugCollisions.add(0)
+	configReloads.add(0, "success")
+	configReloads.add(0, "failure")
+}
+
+// Records a served request
+func Request(route string, code int, d time.Duration) {
+	requests.inc(route, strconv.Itoa(code))
+	requestDuration.observe(d.Seconds(), route)
+}
+
+// Records the outcome of a short link visit
+func Redirect(outcome string) {
+	redirects.inc(outcome)
+}
+
+func CaptchaFailure() {
+	captchaFailures.inc()
+}
+
+func SlugCollision() {
+	slugCollisions.inc()
+}
+
+// Records a database operation
+func StoreOperation(operation string, d time.Duration, failed bool) {
+	storeDuration.observe(d.Seconds(), operation)
+	if failed {
+		storeErrors.inc(operation)
+	} else {
+		storeErrors.add(0, operation)
+	}
+}
+
+func ConfigReload(ok bool) {
+	if ok {
+		configReloads.inc("success")
+	} else {
+		configReloads.inc("failure")
+	}
+}
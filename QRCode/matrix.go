@@ -0,0 +1,312 @@
+package QRCode
+
+// The modules of a symbol being built, reserved modules belong to function patterns
+type matrix struct {
+	size     int
+	version  int
+	dark     []bool
+	reserved []bool
+}
+
+func newMatrix(version int) *matrix {
+	size := 17 + 4*version
+	m := &matrix{size: size, version: version, dark: make([]bool, size*size), reserved: make([]bool, size*size)}
+
+	for _, p := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		m.finder(p[0], p[1])
+	}
+	for i := 8; i < size-8; i++ { // timing patterns
+		m.set(i, 6, i%2 == 0)
+		m.set(6, i, i%2 == 0)
+	}
+	centers := alignmentCenters(version)
+	for i, x := range centers {
+		for j, y := range centers {
+			last := len(centers) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 { // would overlap a finder
+				continue
+			}
+			m.alignment(x, y)
+		}
+	}
+
+	// Format information, around the finders, and the dark module
+	for i := 0; i < 9; i++ {
+		m.reserve(8, i)
+		m.reserve(i, 8)
+	}
+	for i := 0; i < 8; i++ {
+		m.reserve(size-1-i, 8)
+		m.reserve(8, size-1-i)
+	}
+	m.set(8, size-8, true)
+
+	if version >= 7 { // version information, next to the top right and bottom left finders
+		for i := 0; i < 6; i++ {
+			for j := 0; j < 3; j++ {
+				m.reserve(size-11+j, i)
+				m.reserve(i, size-11+j)
+			}
+		}
+	}
+	return m
+}
+
+// Centers of the alignment patterns on each axis
+func alignmentCenters(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	n := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + n*2 + 1) / (2*n - 2) * 2
+	}
+	size := 17 + 4*version
+	centers := make([]int, n)
+	centers[0] = 6
+	for i, pos := n-1, size-7; i > 0; i, pos = i-1, pos-step {
+		centers[i] = pos
+	}
+	return centers
+}
+
+func (m *matrix) set(x, y int, dark bool) {
+	m.dark[y*m.size+x] = dark
+	m.reserved[y*m.size+x] = true
+}
+
+func (m *matrix) reserve(x, y int) {
+	m.reserved[y*m.size+x] = true
+}
+
+// A finder pattern with its top left corner at x, y and its separator
+func (m *matrix) finder(x, y int) {
+	for dy := -1; dy <= 7; dy++ {
+		for dx := -1; dx <= 7; dx++ {
+			if x+dx < 0 || y+dy < 0 || x+dx >= m.size || y+dy >= m.size {
+				continue
+			}
+			ring := max(abs(dx-3), abs(dy-3))
+			m.set(x+dx, y+dy, ring != 2 && ring != 4)
+		}
+	}
+}
+
+func (m *matrix) alignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			m.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// Places the codewords in the zigzag order, two columns at a time from the bottom right
+func (m *matrix) placeData(codewords []byte) {
+	bit := 0
+	total := len(codewords) * 8
+	upward := true
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 { // skip the vertical timing pattern
+			right = 5
+		}
+		for i := 0; i < m.size; i++ {
+			y := i
+			if upward {
+				y = m.size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if m.reserved[y*m.size+x] {
+					continue
+				}
+				if bit < total {
+					m.dark[y*m.size+x] = codewords[bit/8]>>uint(7-bit%8)&1 == 1
+				}
+				bit++
+			}
+		}
+		upward = !upward
+	}
+}
+
+var masks = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (y/2+x/3)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// A copy of m with the data modules flipped by a mask pattern
+func (m *matrix) withMask(mask int) *matrix {
+	c := &matrix{size: m.size, version: m.version, dark: make([]bool, len(m.dark)), reserved: m.reserved}
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			i := y*m.size + x
+			c.dark[i] = m.dark[i]
+			if !m.reserved[i] && masks[mask](x, y) {
+				c.dark[i] = !c.dark[i]
+			}
+		}
+	}
+	return c
+}
+
+// Remainder of a BCH code: data followed by the bits of poly's degree, divided by poly
+func bch(data int, poly int) int {
+	degree := 0
+	for p := poly; p > 1; p >>= 1 {
+		degree++
+	}
+	v := data << uint(degree)
+	for i := bitLength(v) - 1; i >= degree; i = bitLength(v) - 1 {
+		v ^= poly << uint(i-degree)
+	}
+	return data<<uint(degree) | v
+}
+
+func bitLength(v int) int {
+	n := 0
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
+
+func (m *matrix) placeFormat(level Level, mask int) {
+	bits := bch(levelBits[level]<<3|mask, 0x537) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	// Around the top left finder, bit 0 is the least significant
+	for i := 0; i <= 5; i++ {
+		m.dark[i*m.size+8] = bit(i)
+	}
+	m.dark[7*m.size+8] = bit(6)
+	m.dark[8*m.size+8] = bit(7)
+	m.dark[8*m.size+7] = bit(8)
+	for i := 9; i < 15; i++ {
+		m.dark[8*m.size+14-i] = bit(i)
+	}
+	// Split between the other two finders
+	for i := 0; i < 8; i++ {
+		m.dark[8*m.size+m.size-1-i] = bit(i)
+	}
+	for i := 8; i < 15; i++ {
+		m.dark[(m.size-15+i)*m.size+8] = bit(i)
+	}
+}
+
+func (m *matrix) placeVersion() {
+	if m.version < 7 {
+		return
+	}
+	bits := bch(m.version, 0x1F25)
+	for i := 0; i < 18; i++ {
+		dark := bits>>uint(i)&1 == 1
+		a, b := m.size-11+i%3, i/3
+		m.dark[b*m.size+a] = dark
+		m.dark[a*m.size+b] = dark
+	}
+}
+
+// Penalty of the symbol, the mask with the lowest one is used. ISO/IEC 18004 section 7.8.3
+func (m *matrix) penalty() int {
+	p := 0
+	at := func(x, y int) bool { return m.dark[y*m.size+x] }
+
+	// Runs of 5 or more modules of the same colour in a row or column
+	for pass := 0; pass < 2; pass++ {
+		for a := 0; a < m.size; a++ {
+			run := 1
+			for b := 1; b < m.size; b++ {
+				cur, prev := at(b, a), at(b-1, a)
+				if pass == 1 {
+					cur, prev = at(a, b), at(a, b-1)
+				}
+				if cur == prev {
+					run++
+					continue
+				}
+				if run >= 5 {
+					p += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				p += run - 2
+			}
+		}
+	}
+
+	// 2x2 blocks of the same colour
+	for y := 0; y < m.size-1; y++ {
+		for x := 0; x < m.size-1; x++ {
+			c := at(x, y)
+			if c == at(x+1, y) && c == at(x, y+1) && c == at(x+1, y+1) {
+				p += 3
+			}
+		}
+	}
+
+	// Patterns that look like finders: 1:1:3:1:1 with 4 light modules on a side
+	finderLike := func(get func(i int) bool) int {
+		n := 0
+		pattern := []bool{true, false, true, true, true, false, true}
+		for i := 0; i+7 <= m.size; i++ {
+			match := true
+			for j, d := range pattern {
+				if get(i+j) != d {
+					match = false
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+			light := func(from, to int) bool {
+				for k := from; k < to; k++ {
+					if k >= 0 && k < m.size && get(k) {
+						return false
+					}
+				}
+				return true
+			}
+			if light(i-4, i) || light(i+7, i+11) {
+				n += 40
+			}
+		}
+		return n
+	}
+	for a := 0; a < m.size; a++ {
+		row, col := a, a
+		p += finderLike(func(i int) bool { return at(i, row) })
+		p += finderLike(func(i int) bool { return at(col, i) })
+	}
+
+	// Balance of dark and light modules
+	dark := 0
+	for _, d := range m.dark {
+		if d {
+			dark++
+		}
+	}
+	percent := dark * 100 / len(m.dark)
+	p += abs(percent-50) / 5 * 10
+	return p
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
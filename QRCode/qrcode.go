@@ -0,0 +1,178 @@
+// Package QRCode encodes QR codes (ISO/IEC 18004) in byte mode and renders them as PNG or SVG
+package QRCode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error correction level, how much of the symbol can be damaged and still be read
+type Level int
+
+const (
+	Low      Level = iota // recovers 7%
+	Medium                // recovers 15%
+	Quartile              // recovers 25%
+	High                  // recovers 30%
+)
+
+// Parses a level given as L, M, Q or H
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "L":
+		return Low, nil
+	case "M":
+		return Medium, nil
+	case "Q":
+		return Quartile, nil
+	case "H":
+		return High, nil
+	}
+	return 0, fmt.Errorf("unknown error correction level %q, use L, M, Q or H", s)
+}
+
+// Format information bits of each level
+var levelBits = [4]int{Low: 1, Medium: 0, Quartile: 3, High: 2}
+
+var ErrTooLong = errors.New("data too long for a QR code")
+
+// A QR code, Size modules per side without the quiet zone
+type Code struct {
+	Size    int
+	Version int
+	Level   Level
+	dark    []bool
+}
+
+// Whether the module at column x, row y is dark
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.dark[y*c.Size+x]
+}
+
+// Encodes data in the smallest version that fits it at level
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < Low || level > High {
+		return nil, fmt.Errorf("unknown error correction level %d", level)
+	}
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+countBits(v)+8*len(data) <= 8*dataCodewords(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := interleave(version, level, encodeData(data, version, level))
+	m := newMatrix(version)
+	m.placeData(codewords)
+
+	best, bestPenalty := -1, 0
+	var bestModules []bool
+	for mask := 0; mask < 8; mask++ {
+		masked := m.withMask(mask)
+		masked.placeFormat(level, mask)
+		masked.placeVersion()
+		if p := masked.penalty(); best < 0 || p < bestPenalty {
+			best, bestPenalty, bestModules = mask, p, masked.dark
+		}
+	}
+	return &Code{Size: m.size, Version: version, Level: level, dark: bestModules}, nil
+}
+
+// Bits of the character count indicator in byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func dataCodewords(version int, level Level) int {
+	b := blocks[version-1][level]
+	return b.blocks1*b.data1 + b.blocks2*b.data2
+}
+
+// Builds the data codewords: mode, count, data, terminator and padding
+func encodeData(data []byte, version int, level Level) []byte {
+	var bits bitWriter
+	bits.write(0x4, 4) // byte mode
+	bits.write(len(data), countBits(version))
+	for _, b := range data {
+		bits.write(int(b), 8)
+	}
+	capacity := 8 * dataCodewords(version, level)
+	for i := 0; i < 4 && bits.len() < capacity; i++ {
+		bits.write(0, 1)
+	}
+	for bits.len()%8 != 0 {
+		bits.write(0, 1)
+	}
+	out := bits.bytes()
+	for pad := 0; len(out) < capacity/8; pad++ {
+		out = append(out, [2]byte{0xEC, 0x11}[pad%2])
+	}
+	return out
+}
+
+// Splits the data in blocks, adds their error correction and interleaves them
+func interleave(version int, level Level, data []byte) []byte {
+	b := blocks[version-1][level]
+	var dataBlocks, ecBlocks [][]byte
+	for i := 0; i < b.blocks1+b.blocks2; i++ {
+		n := b.data1
+		if i >= b.blocks1 {
+			n = b.data2
+		}
+		block := data[:n]
+		data = data[n:]
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, reedSolomon(block, b.ec))
+	}
+
+	var out []byte
+	for i := 0; i < b.data1 || i < b.data2; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < b.ec; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *bitWriter) write(v int, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[w.n/8] |= 0x80 >> uint(w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) len() int {
+	return w.n
+}
+
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,153 @@
+package QRCode
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Symbols of ISO/IEC 18004 Annex I and the thonky.com tutorial, both version 1-M
+func TestReedSolomon(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		ec   []byte
+	}{
+		{"01234567", []byte{
+			0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11,
+		}, []byte{165, 36, 212, 193, 237, 54, 199, 135, 44, 85}},
+		{"HELLO WORLD", []byte{
+			32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17,
+		}, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reedSolomon(tt.data, len(tt.ec)); !bytes.Equal(got, tt.ec) {
+				t.Errorf("got %v, want %v", got, tt.ec)
+			}
+		})
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	tests := []struct {
+		data    string
+		version int
+		level   Level
+		want    []byte
+	}{
+		{"hello", 1, Medium, []byte{
+			0x40, 0x56, 0x86, 0x56, 0xC6, 0xC6, 0xF0, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC,
+		}},
+		{"", 1, High, []byte{0x40, 0x00, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC}},
+		// Full capacity, the terminator fills the last codeword
+		{"0123456", 1, High, []byte{0x40, 0x73, 0x03, 0x13, 0x23, 0x33, 0x43, 0x53, 0x60}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q %d-%d", tt.data, tt.version, tt.level), func(t *testing.T) {
+			if got := encodeData([]byte(tt.data), tt.version, tt.level); !bytes.Equal(got, tt.want) {
+				t.Errorf("got % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+// Format and version information of ISO/IEC 18004 Annex C and D
+func TestBCH(t *testing.T) {
+	tests := []struct {
+		name string
+		got  int
+		want string
+	}{
+		{"L mask 0", bch(levelBits[Low]<<3|0, 0x537) ^ 0x5412, "111011111000100"},
+		{"L mask 4", bch(levelBits[Low]<<3|4, 0x537) ^ 0x5412, "110011000101111"},
+		{"M mask 0", bch(levelBits[Medium]<<3|0, 0x537) ^ 0x5412, "101010000010010"},
+		{"M mask 5", bch(levelBits[Medium]<<3|5, 0x537) ^ 0x5412, "100000011001110"},
+		{"Q mask 0", bch(levelBits[Quartile]<<3|0, 0x537) ^ 0x5412, "011010101011111"},
+		{"H mask 0", bch(levelBits[High]<<3|0, 0x537) ^ 0x5412, "001011010001001"},
+		{"version 7", bch(7, 0x1F25), "000111110010010100"},
+		{"version 40", bch(40, 0x1F25), "101000110001101001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprintf("%0*b", len(tt.want), tt.got); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The smallest version that fits, by the byte mode capacities of ISO/IEC 18004 table 7
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		length  int
+		level   Level
+		version int
+		err     error
+	}{
+		{17, Low, 1, nil},
+		{18, Low, 2, nil},
+		{7, High, 1, nil},
+		{8, High, 2, nil},
+		{130, Quartile, 9, nil},
+		{131, Quartile, 10, nil},
+		{154, Low, 7, nil},
+		{155, Low, 8, nil},
+		{2953, Low, 40, nil},
+		{2954, Low, 0, ErrTooLong},
+		{1273, High, 40, nil},
+		{1274, High, 0, ErrTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d bytes %d", tt.length, tt.level), func(t *testing.T) {
+			c, err := Encode(bytes.Repeat([]byte("a"), tt.length), tt.level)
+			if err != tt.err {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if c.Version != tt.version || c.Size != 17+4*tt.version {
+				t.Errorf("version %d size %d, want version %d", c.Version, c.Size, tt.version)
+			}
+			// The format information is written twice, both copies must agree with one of the masks
+			var first, second strings.Builder
+			for i := 0; i <= 5; i++ {
+				first.WriteString(module(c, 8, i))
+			}
+			first.WriteString(module(c, 8, 7) + module(c, 8, 8) + module(c, 7, 8))
+			for i := 9; i < 15; i++ {
+				first.WriteString(module(c, 14-i, 8))
+			}
+			for i := 0; i < 8; i++ {
+				second.WriteString(module(c, c.Size-1-i, 8))
+			}
+			for i := 8; i < 15; i++ {
+				second.WriteString(module(c, 8, c.Size-15+i))
+			}
+			if first.String() != second.String() {
+				t.Fatalf("format information %s and %s differ", first.String(), second.String())
+			}
+			found := false
+			for mask := 0; mask < 8; mask++ {
+				bits := bch(levelBits[tt.level]<<3|mask, 0x537) ^ 0x5412
+				var want strings.Builder
+				for i := 0; i < 15; i++ {
+					want.WriteString(fmt.Sprint(bits >> uint(i) & 1))
+				}
+				found = found || want.String() == first.String()
+			}
+			if !found {
+				t.Errorf("format information %s is not one of level %d", first.String(), tt.level)
+			}
+		})
+	}
+}
+
+func module(c *Code, x, y int) string {
+	if c.Dark(x, y) {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,59 @@
+package QRCode
+
+// Arithmetic in GF(256) with the primitive polynomial x^8+x^4+x^3+x^2+1
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// The generator polynomial of degree n, coefficients from the highest degree down
+// without the leading 1
+func generator(n int) []byte {
+	g := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(g)+1)
+		for j, c := range g {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		g = next
+	}
+	return g[1:]
+}
+
+// The n error correction codewords of data
+func reedSolomon(data []byte, n int) []byte {
+	g := generator(n)
+	rem := make([]byte, n)
+	for _, d := range data {
+		factor := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(g[i], factor)
+		}
+	}
+	return rem
+}
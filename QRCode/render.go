@@ -0,0 +1,176 @@
+package QRCode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Modules of light border around the symbol, as required by the spec
+const QuietZone = 4
+
+// How a code is drawn
+type Style struct {
+	Size       int         // width and height in pixels, rounded down to a whole number of pixels per module
+	Foreground color.Color // dark modules, black when nil
+	Background color.Color // light modules, white when nil
+	Logo       image.Image // drawn over the center when not nil, encode with High or Quartile so the code stays readable
+}
+
+// Side of the logo as a fraction of the symbol's
+const logoFraction = 4
+
+func (s Style) colors() (fg, bg color.Color) {
+	fg, bg = s.Foreground, s.Background
+	if fg == nil {
+		fg = color.Black
+	}
+	if bg == nil {
+		bg = color.White
+	}
+	return
+}
+
+// Pixels per module and the resulting image side
+func (c *Code) scale(size int) (scale, side int) {
+	modules := c.Size + 2*QuietZone
+	scale = size / modules
+	if scale < 1 {
+		scale = 1
+	}
+	return scale, scale * modules
+}
+
+// Where the logo goes, centered and aligned to modules
+func (c *Code) logoRect(scale int) image.Rectangle {
+	n := c.Size / logoFraction
+	if n%2 != c.Size%2 { // keeps it centered
+		n++
+	}
+	min := (QuietZone + (c.Size-n)/2) * scale
+	return image.Rect(min, min, min+n*scale, min+n*scale)
+}
+
+// Draws the code
+func (c *Code) Image(s Style) image.Image {
+	fg, bg := s.colors()
+	scale, side := c.scale(s.Size)
+	img := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(img, img.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	dark := image.NewUniform(fg)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				px, py := (x+QuietZone)*scale, (y+QuietZone)*scale
+				r := image.Rect(px, py, px+scale, py+scale)
+				draw.Draw(img, r, dark, image.Point{}, draw.Src)
+			}
+		}
+	}
+	if s.Logo != nil {
+		r := c.logoRect(scale)
+		draw.Draw(img, r, image.NewUniform(bg), image.Point{}, draw.Src)
+		padding := scale
+		draw.Draw(img, r.Inset(padding), fit(s.Logo, r.Dx()-2*padding), image.Point{}, draw.Over)
+	}
+	return img
+}
+
+// Writes the code as a PNG
+func (c *Code) PNG(w io.Writer, s Style) error {
+	return png.Encode(w, c.Image(s))
+}
+
+// Writes the code as an SVG, dark modules are drawn as a single path
+func (c *Code) SVG(w io.Writer, s Style) error {
+	fg, bg := s.colors()
+	_, side := c.scale(s.Size)
+	modules := c.Size + 2*QuietZone
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		side, side, modules, modules)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="%s"/>`, hex(bg))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hex(fg))
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			run := 1 // merges horizontal runs of dark modules
+			for c.Dark(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+QuietZone, y+QuietZone, run, run)
+			x += run - 1
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if s.Logo != nil {
+		r := c.logoRect(1)
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`, r.Min.X, r.Min.Y, r.Dx(), r.Dy(), hex(bg))
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, s.Logo); err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`,
+			r.Min.X+1, r.Min.Y+1, r.Dx()-2, r.Dy()-2, base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	buf.WriteString("</svg>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Scales img to fit a side x side square keeping its aspect ratio, nearest neighbour is good enough for a logo
+func fit(img image.Image, side int) image.Image {
+	b := img.Bounds()
+	if side <= 0 || b.Empty() {
+		return image.NewRGBA(image.Rectangle{})
+	}
+	w, h := side, side
+	if b.Dx() > b.Dy() {
+		h = side * b.Dy() / b.Dx()
+	} else {
+		w = side * b.Dx() / b.Dy()
+	}
+	out := image.NewRGBA(image.Rect(0, 0, side, side))
+	offX, offY := (side-w)/2, (side-h)/2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Set(offX+x, offY+y, img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return out
+}
+
+func hex(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// Parses a colour written as #rgb, #rrggbb or #rrggbbaa
+func ParseColor(s string) (color.Color, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	if len(h) == 6 {
+		h += "ff"
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil || len(h) != 8 || !strings.HasPrefix(s, "#") {
+		return nil, fmt.Errorf("invalid colour %q, use #rrggbb", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
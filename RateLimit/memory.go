@@ -0,0 +1,70 @@
+package RateLimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Buckets that have been idle for this long are full again and can be forgotten
+const sweepInterval = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// In-process token bucket, each client gets its own bucket of burst tokens
+// refilled at requests/period tokens per second
+type memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	rate      float64 // tokens per second
+	burst     float64
+	lastSweep time.Time
+}
+
+func newMemory(requests int, period time.Duration, burst int) *memory {
+	return &memory{
+		buckets:   make(map[string]*bucket),
+		rate:      float64(requests) / period.Seconds(),
+		burst:     float64(burst),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *memory) Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: m.burst, last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(m.burst, b.tokens+now.Sub(b.last).Seconds()*m.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	retryAfter = time.Duration((1 - b.tokens) / m.rate * float64(time.Second))
+	return false, retryAfter, nil
+}
+
+// Drops the buckets that would be full by now
+func (m *memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*m.rate >= m.burst {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,48 @@
+package RateLimit
+
+import (
+	"context"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Fixed window counters stored in MongoDB so every replica sees the same counts.
+// A client can make requests requests per window of period
+type mongoDB struct {
+	db         *Config.MongoDB
+	collection string
+	name       string
+	requests   int
+	period     time.Duration
+	index      sync.Once
+}
+
+func newMongoDB(db *Config.MongoDB, collection string, name string, requests int, period time.Duration) *mongoDB {
+	return &mongoDB{db: db, collection: collection, name: name, requests: requests, period: period}
+}
+
+func (m *mongoDB) Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error) {
+	m.index.Do(func() {
+		if err := DataBase.EnsureExpireIndex(ctx, m.db, m.collection); err != nil {
+			Log.Error("error creating rate limit index", "collection", m.collection, "error", err)
+		}
+	})
+
+	now := time.Now()
+	window := now.Truncate(m.period)
+	end := window.Add(m.period)
+	counter := m.name + "|" + key + "|" + strconv.FormatInt(window.Unix(), 10)
+
+	count, err := DataBase.IncreaseCounter(ctx, m.db, m.collection, counter, end)
+	if err != nil {
+		return
+	}
+	if count > m.requests {
+		return false, end.Sub(now), nil
+	}
+	return true, 0, nil
+}
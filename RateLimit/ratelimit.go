@@ -0,0 +1,39 @@
+package RateLimit
+
+import (
+	"context"
+	"gShort/Config"
+	"time"
+)
+
+// A Limiter decides whether the client identified by key can make one more request.
+// When it can't, retryAfter is how long the client should wait before trying again
+type Limiter interface {
+	Allow(ctx context.Context, key string) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// Returns the Limiter for the given policy using the configured backend.
+// name identifies the policy so different policies never share counters.
+// A nil Limiter is returned when the policy is disabled
+func New(config *Config.RateLimit, db *Config.MongoDB, name string, policy *Config.RateLimitPolicy) Limiter {
+	if policy == nil || policy.Requests <= 0 {
+		return nil
+	}
+	period := time.Duration(policy.Period) * time.Second
+	if period <= 0 {
+		period = time.Minute
+	}
+	burst := policy.Burst
+	if burst <= 0 {
+		burst = policy.Requests
+	}
+
+	if config.Backend == "mongodb" {
+		collection := config.Collection
+		if collection == "" {
+			collection = "ratelimits"
+		}
+		return newMongoDB(db, collection, name, policy.Requests, period)
+	}
+	return newMemory(policy.Requests, period, burst)
+}
@@ -0,0 +1,237 @@
+package Tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gShort/Config"
+	"gShort/Log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 512
+	maxQueue      = 4096
+	flushInterval = 5 * time.Second
+)
+
+// Sends finished spans in batches to an OTLP/HTTP endpoint using the JSON encoding
+type exporter struct {
+	url         string
+	headers     map[string]string
+	service     string
+	sampleRatio float64
+	client      *http.Client
+
+	mu    sync.Mutex
+	queue []*Span
+	flush chan struct{}
+	done  chan struct{}
+}
+
+var (
+	mu     sync.RWMutex
+	active *exporter
+)
+
+func current() *exporter {
+	mu.RLock()
+	defer mu.RUnlock()
+	return active
+}
+
+// Starts exporting spans as configured, tracing stays off when config is nil or has no Endpoint
+func Setup(config *Config.Tracing) error {
+	if config == nil || config.Endpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(config.Endpoint, "http://") && !strings.HasPrefix(config.Endpoint, "https://") {
+		return fmt.Errorf("tracing endpoint %q must be an http(s) URL", config.Endpoint)
+	}
+	e := &exporter{
+		url:         strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces",
+		headers:     config.Headers,
+		service:     config.ServiceName,
+		sampleRatio: config.SampleRatio,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		flush:       make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	if e.service == "" {
+		e.service = "gshort"
+	}
+	if e.sampleRatio <= 0 || e.sampleRatio > 1 {
+		e.sampleRatio = 1
+	}
+	mu.Lock()
+	active = e
+	mu.Unlock()
+	go e.run()
+	return nil
+}
+
+// Exports the queued spans and stops tracing
+func Shutdown(ctx context.Context) {
+	mu.Lock()
+	e := active
+	active = nil
+	mu.Unlock()
+	if e == nil {
+		return
+	}
+	close(e.done)
+	e.export(ctx)
+}
+
+// Samples a fraction of the traces, deterministically by trace ID
+func (e *exporter) sample(traceID [16]byte) bool {
+	if e.sampleRatio >= 1 {
+		return true
+	}
+	return float64(binary.BigEndian.Uint64(traceID[8:])>>1)/(1<<63) < e.sampleRatio
+}
+
+func (e *exporter) enqueue(s *Span) {
+	e.mu.Lock()
+	if len(e.queue) < maxQueue {
+		e.queue = append(e.queue, s)
+	}
+	full := len(e.queue) >= batchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), flushInterval)
+		e.export(ctx)
+		cancel()
+	}
+}
+
+func (e *exporter) export(ctx context.Context) {
+	e.mu.Lock()
+	spans := e.queue
+	e.queue = nil
+	e.mu.Unlock()
+
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > batchSize {
+			n = batchSize
+		}
+		if err := e.send(ctx, spans[:n]); err != nil {
+			Log.Warn("error exporting spans", "spans", n, "error", err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (e *exporter) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %v", res.Status)
+	}
+	return nil
+}
+
+type keyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func attribute(key string, value interface{}) keyValue {
+	v := map[string]interface{}{}
+	switch value := value.(type) {
+	case string:
+		v["stringValue"] = value
+	case bool:
+		v["boolValue"] = value
+	case int:
+		v["intValue"] = strconv.Itoa(value)
+	case int64:
+		v["intValue"] = strconv.FormatInt(value, 10)
+	case float64:
+		v["doubleValue"] = value
+	default:
+		v["stringValue"] = fmt.Sprint(value)
+	}
+	return keyValue{Key: key, Value: v}
+}
+
+// Builds an OTLP ExportTraceServiceRequest
+func (e *exporter) payload(spans []*Span) interface{} {
+	var out []map[string]interface{}
+	for _, s := range spans {
+		s.mu.Lock()
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.sc.traceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.spanID[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != ([8]byte{}) {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		var attributes []keyValue
+		for k, v := range s.attributes {
+			attributes = append(attributes, attribute(k, v))
+		}
+		span["attributes"] = attributes
+		if s.err != "" {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.err}
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []keyValue{attribute("service.name", e.service)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "gShort"},
+				"spans": out,
+			}},
+		}},
+	}
+}
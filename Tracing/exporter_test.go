@@ -0,0 +1,174 @@
+package Tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gShort/Config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// What a collector gets, the fields of ExportTraceServiceRequest the exporter sends
+type exportRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string     `json:"traceId"`
+				SpanID       string     `json:"spanId"`
+				ParentSpanID string     `json:"parentSpanId"`
+				Name         string     `json:"name"`
+				Kind         int        `json:"kind"`
+				Start        string     `json:"startTimeUnixNano"`
+				End          string     `json:"endTimeUnixNano"`
+				Attributes   []keyValue `json:"attributes"`
+				Status       *struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+// Starts a local collector and tracing to it, the requests it got are sent on the channel.
+// stop shuts both down
+func collector(t *testing.T, config Config.Tracing) (requests <-chan exportRequest, stop func()) {
+	received := make(chan exportRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s %s", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("no configured header: %v", r.Header)
+		}
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		received <- req
+	}))
+	config.Endpoint = srv.URL + "/"
+	config.Headers = map[string]string{"Authorization": "Bearer token"}
+	if err := Setup(&config); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return received, func() {
+		Shutdown(context.Background())
+		srv.Close()
+	}
+}
+
+func TestExport(t *testing.T) {
+	requests, stop := collector(t, Config.Tracing{ServiceName: "gshort-test"})
+	defer stop()
+
+	// A request of a traced client, which calls another service
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	r := httptest.NewRequest(http.MethodGet, "/promo", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	ctx, server := Start(Extract(r.Context(), r), "GET /{slug}", KindServer)
+	server.SetAttribute("http.status_code", 302)
+	server.SetAttribute("http.route", "/{slug}")
+	ctx, client := Start(ctx, "recaptcha verify", KindClient)
+	client.SetError(errors.New("failed reCaptcha"))
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	client.End()
+	server.End()
+	Shutdown(context.Background())
+
+	req := <-requests
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected payload %+v", req)
+	}
+	if got := req.ResourceSpans[0].Resource.Attributes; len(got) != 1 || got[0].Key != "service.name" || got[0].Value["stringValue"] != "gshort-test" {
+		t.Errorf("resource attributes %v", got)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+	c, s := spans[0], spans[1] // in the order they ended
+	for _, span := range spans {
+		if span.TraceID != traceID {
+			t.Errorf("%s: trace %s, want the incoming %s", span.Name, span.TraceID, traceID)
+		}
+		start, err1 := strconv.ParseInt(span.Start, 10, 64)
+		end, err2 := strconv.ParseInt(span.End, 10, 64)
+		if len(span.SpanID) != 16 || err1 != nil || err2 != nil || start == 0 || end < start {
+			t.Errorf("%s: span %q from %s to %s", span.Name, span.SpanID, span.Start, span.End)
+		}
+	}
+	if s.Name != "GET /{slug}" || s.Kind != KindServer || s.ParentSpanID != parentID || s.Status != nil {
+		t.Errorf("server span %+v", s)
+	}
+	attributes := make(map[string]map[string]interface{})
+	for _, a := range s.Attributes {
+		attributes[a.Key] = a.Value
+	}
+	if attributes["http.status_code"]["intValue"] != "302" || attributes["http.route"]["stringValue"] != "/{slug}" {
+		t.Errorf("server span attributes %v", attributes)
+	}
+	if c.Name != "recaptcha verify" || c.Kind != KindClient || c.ParentSpanID != s.SpanID {
+		t.Errorf("client span %+v, want a child of %s", c, s.SpanID)
+	}
+	if c.Status == nil || c.Status.Code != 2 || c.Status.Message != "failed reCaptcha" {
+		t.Errorf("client span status %+v", c.Status)
+	}
+	if want := "00-" + traceID + "-" + c.SpanID + "-01"; outgoing.Get("traceparent") != want {
+		t.Errorf("sent traceparent %q, want %q", outgoing.Get("traceparent"), want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	_, stop := collector(t, Config.Tracing{SampleRatio: 1})
+	defer stop()
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		traceparent string
+		continued   bool
+		sampled     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false}, // propagated, not recorded
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", false, true},
+		{"", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.traceparent, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("traceparent", tt.traceparent)
+			ctx, span := Start(Extract(r.Context(), r), "GET /", KindServer)
+			defer span.End()
+			if got := TraceID(ctx) == traceID; got != tt.continued {
+				t.Errorf("trace %s continued: %v, want %v", TraceID(ctx), got, tt.continued)
+			}
+			if (span != nil) != tt.sampled {
+				t.Errorf("span recorded: %v, want %v", span != nil, tt.sampled)
+			}
+			h := http.Header{}
+			Inject(ctx, h)
+			flags := "-00"
+			if tt.sampled {
+				flags = "-01"
+			}
+			if got := h.Get("traceparent"); !strings.HasPrefix(got, "00-"+TraceID(ctx)+"-") || !strings.HasSuffix(got, flags) {
+				t.Errorf("sent traceparent %q, want the trace %s and flags %s", got, TraceID(ctx), flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package Tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+// A Span times one operation. Methods of a nil Span do nothing, that's what
+// Start returns when tracing is off or the trace is not sampled
+type Span struct {
+	mu         sync.Mutex
+	sc         spanContext
+	parent     [8]byte
+	name       string
+	kind       int
+	start, end time.Time
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+type contextKey struct{}
+
+func fromContext(ctx context.Context) (spanContext, bool) {
+	switch v := ctx.Value(contextKey{}).(type) {
+	case *Span:
+		return v.sc, true
+	case spanContext: // remote parent
+		return v, true
+	}
+	return spanContext{}, false
+}
+
+// Starts a span as a child of the span in ctx, or of the remote parent extracted
+// from the request, and returns a context holding it
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	e := current()
+	if e == nil {
+		return ctx, nil
+	}
+	parent, ok := fromContext(ctx)
+	sc := spanContext{sampled: parent.sampled}
+	if ok {
+		sc.traceID = parent.traceID
+	} else {
+		_, _ = rand.Read(sc.traceID[:])
+		sc.sampled = e.sample(sc.traceID)
+	}
+	_, _ = rand.Read(sc.spanID[:])
+	if !sc.sampled {
+		// Keep propagating the trace without recording it
+		return context.WithValue(ctx, contextKey{}, sc), nil
+	}
+
+	s := &Span{sc: sc, name: name, kind: kind, start: time.Now(), attributes: make(map[string]interface{})}
+	if ok {
+		s.parent = parent.spanID
+	}
+	return context.WithValue(ctx, contextKey{}, s), s
+}
+
+// Sets an attribute, values can be strings, bools, ints or floats
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// Marks the span as failed when err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// Ends the span and queues it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if e := current(); e != nil {
+		e.enqueue(s)
+	}
+}
+
+// Returns the trace ID of the span in ctx, empty when there is none
+func TraceID(ctx context.Context) string {
+	sc, ok := fromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return hex.EncodeToString(sc.traceID[:])
+}
+
+// Returns ctx with the remote parent found in the W3C traceparent header of r
+func Extract(ctx context.Context, r *http.Request) context.Context {
+	if current() == nil {
+		return ctx
+	}
+	parts := strings.Split(strings.TrimSpace(r.Header.Get("traceparent")), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 || (parts[0] == "00" && len(parts) != 4) {
+		return ctx
+	}
+	var sc spanContext
+	traceID, err1 := hex.DecodeString(parts[1])
+	spanID, err2 := hex.DecodeString(parts[2])
+	flags, err3 := hex.DecodeString(parts[3])
+	if err1 != nil || err2 != nil || err3 != nil || len(traceID) != 16 || len(spanID) != 8 || len(flags) != 1 {
+		return ctx
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	if sc.traceID == ([16]byte{}) || sc.spanID == ([8]byte{}) {
+		return ctx
+	}
+	sc.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// Sets the W3C traceparent header of an outgoing request from the span in ctx
+func Inject(ctx context.Context, h http.Header) {
+	sc, ok := fromContext(ctx)
+	if !ok {
+		return
+	}
+	flags := "00"
+	if sc.sampled {
+		flags = "01"
+	}
+	h.Set("traceparent", "00-"+hex.EncodeToString(sc.traceID[:])+"-"+hex.EncodeToString(sc.spanID[:])+"-"+flags)
+}
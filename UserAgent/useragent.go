@@ -0,0 +1,83 @@
+// Package UserAgent tells the operating system, device class and browser of a client
+// from its User-Agent header. It only knows the clients worth targeting, anything else is left empty
+package UserAgent
+
+import "strings"
+
+// Operating systems, device classes and browsers Parse can return
+var (
+	OSes     = []string{"ios", "android", "windows", "macos", "linux", "chromeos"}
+	Devices  = []string{"mobile", "tablet", "desktop", "bot"}
+	Browsers = []string{"chrome", "firefox", "safari", "edge", "opera", "samsung", "ie"}
+)
+
+// What a User-Agent header tells about a client, empty when unknown
+type Client struct {
+	OS      string
+	Device  string
+	Browser string
+}
+
+// Crawlers and link previews, they are not browsing
+var bots = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "bingpreview", "whatsapp", "headlesschrome"}
+
+// Order matters: Edge and Opera say they are Chrome, which says it is Safari
+var browsers = []struct {
+	name    string
+	markers []string
+}{
+	{"edge", []string{"edg/", "edge/", "edga/", "edgios/"}},
+	{"opera", []string{"opr/", "opera", "opios/"}},
+	{"samsung", []string{"samsungbrowser/"}},
+	{"firefox", []string{"firefox/", "fxios/"}},
+	{"chrome", []string{"chrome/", "crios/", "chromium/"}},
+	{"safari", []string{"safari/"}},
+	{"ie", []string{"msie ", "trident/"}},
+}
+
+// Parses a User-Agent header
+func Parse(ua string) (c Client) {
+	ua = strings.ToLower(ua)
+	has := func(markers ...string) bool {
+		for _, m := range markers {
+			if strings.Contains(ua, m) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case has("iphone", "ipad", "ipod"): // they also say "like Mac OS X"
+		c.OS = "ios"
+	case has("android"):
+		c.OS = "android"
+	case has("cros "):
+		c.OS = "chromeos"
+	case has("windows"):
+		c.OS = "windows"
+	case has("macintosh", "mac os x"):
+		c.OS = "macos"
+	case has("linux", "x11"):
+		c.OS = "linux"
+	}
+
+	switch {
+	case has(bots...):
+		c.Device = "bot"
+	case has("ipad", "tablet") || c.OS == "android" && !has("mobile"):
+		c.Device = "tablet"
+	case has("mobi", "iphone", "ipod", "windows phone"):
+		c.Device = "mobile"
+	case c.OS != "":
+		c.Device = "desktop"
+	}
+
+	for _, b := range browsers {
+		if has(b.markers...) {
+			c.Browser = b.name
+			break
+		}
+	}
+	return c
+}
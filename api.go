@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/subtle"
+	"gShort/Config"
+	"net/http"
+)
+
+// Paths of the API, it's only served to clients with one of the APIKeys
+const (
+	pathLinks = "/api/links"
+	pathBulk  = "/api/links/bulk"
+)
+
+// Whether r carries one of the APIKeys in X-API-Key, answers 401 when it doesn't.
+// Without APIKeys the API can't be used
+func authorized(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) bool {
+	key := r.Header.Get("X-API-Key")
+	if key != "" {
+		for _, k := range config.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				return true
+			}
+		}
+	}
+	apiError(w, r, th, config, http.StatusUnauthorized, "invalid_api_key")
+	return false
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gShort/Config"
+	"gShort/DataBase"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Backups are gzipped tar archives that don't depend on how the store keeps the data:
+//
+//	manifest.json  backupManifest, always the first entry
+//	settings.json  the config the backup was made with, API keys included
+//	links.jsonl    one backupLink per line
+//	clicks.jsonl   one backupClick per line
+//
+// Readers refuse archives with a newer version than backupVersion
+const (
+	backupFormat  = "gshort-backup"
+	backupVersion = 1
+)
+
+type backupManifest struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Domains []string  `json:"domains"`
+	Links   int       `json:"links"`
+	Clicks  int       `json:"clicks"`
+}
+
+// A link as it is backed up, times are zero when unset
+type backupLink struct {
+	Domain       string          `json:"domain"`
+	Slug         string          `json:"slug"`
+	Url          string          `json:"url"`
+	Password     string          `json:"password,omitempty"`
+	Hits         int             `json:"hits"`
+	MaxHitCount  int             `json:"maxhitcount"`
+	Disabled     bool            `json:"disabled"`
+	Prefix       bool            `json:"prefix"`
+	ForwardQuery *bool           `json:"forwardquery,omitempty"`
+	UTM          *DataBase.UTM   `json:"utm,omitempty"`
+	Redirect     string          `json:"redirect,omitempty"`
+	Rules        []DataBase.Rule `json:"rules,omitempty"`
+	Geo          *DataBase.Geo   `json:"geo,omitempty"`
+	Split        *DataBase.Split `json:"split,omitempty"`
+	Alias        bool            `json:"alias,omitempty"`
+	Created      time.Time       `json:"created"`
+	Expires      time.Time       `json:"expires"`
+}
+
+func newBackupLink(domain string, r *DataBase.Record) backupLink {
+	return backupLink{
+		Domain:       domain,
+		Slug:         r.Mapping,
+		Url:          r.Url,
+		Password:     r.Password,
+		Hits:         r.HitCount,
+		MaxHitCount:  r.MaxHitCount,
+		Disabled:     r.Disabled,
+		Prefix:       r.Prefix,
+		ForwardQuery: r.ForwardQuery,
+		UTM:          r.UTM,
+		Redirect:     r.Redirect,
+		Rules:        r.Rules,
+		Geo:          r.Geo,
+		Split:        r.Split,
+		Alias:        r.Alias,
+		Created:      r.CreatedAt,
+		Expires:      r.ExpiresAt,
+	}
+}
+
+func (l backupLink) record() *DataBase.Record {
+	return &DataBase.Record{
+		Url:          l.Url,
+		Mapping:      l.Slug,
+		Password:     l.Password,
+		HitCount:     l.Hits,
+		MaxHitCount:  l.MaxHitCount,
+		Disabled:     l.Disabled,
+		Prefix:       l.Prefix,
+		ForwardQuery: l.ForwardQuery,
+		UTM:          l.UTM,
+		Redirect:     l.Redirect,
+		Rules:        l.Rules,
+		Geo:          l.Geo,
+		Split:        l.Split,
+		Alias:        l.Alias,
+		CreatedAt:    l.Created,
+		ExpiresAt:    l.Expires,
+	}
+}
+
+// A click as it is backed up, see DataBase.Click
+type backupClick struct {
+	Domain  string    `json:"domain"`
+	Slug    string    `json:"slug"`
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule,omitempty"`
+	OS      string    `json:"os,omitempty"`
+	Device  string    `json:"device,omitempty"`
+	Browser string    `json:"browser,omitempty"`
+	Country string    `json:"country,omitempty"`
+	Variant string    `json:"variant,omitempty"`
+}
+
+func newBackupClick(domain string, c *DataBase.Click) backupClick {
+	return backupClick{
+		Domain:  domain,
+		Slug:    c.Mapping,
+		Time:    c.Time,
+		Rule:    c.Rule,
+		OS:      c.OS,
+		Device:  c.Device,
+		Browser: c.Browser,
+		Country: c.Country,
+		Variant: c.Variant,
+	}
+}
+
+func (c backupClick) click() *DataBase.Click {
+	return &DataBase.Click{
+		Mapping: c.Slug,
+		Time:    c.Time,
+		Rule:    c.Rule,
+		OS:      c.OS,
+		Device:  c.Device,
+		Browser: c.Browser,
+		Country: c.Country,
+		Variant: c.Variant,
+	}
+}
+
+// A temporary file entries are written to before they are archived, tar needs their size up front
+type spool struct {
+	*os.File
+	buffered *bufio.Writer
+	enc      *json.Encoder
+}
+
+func newSpool(name string) (*spool, error) {
+	f, err := ioutil.TempFile("", name)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{File: f, buffered: bufio.NewWriter(f)}
+	s.enc = json.NewEncoder(s.buffered)
+	return s, nil
+}
+
+// Flushes the spool and rewinds it to be read, returns its size
+func (s *spool) rewind() (int64, error) {
+	if err := s.buffered.Flush(); err != nil {
+		return 0, err
+	}
+	size, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.Seek(0, io.SeekStart)
+	return size, err
+}
+
+func (s *spool) remove() {
+	s.Close()
+	os.Remove(s.Name())
+}
+
+// Writes a backup of every domain of config to w
+func backup(ctx context.Context, config *Config.Config, w io.Writer) (manifest backupManifest, err error) {
+	manifest = backupManifest{Format: backupFormat, Version: backupVersion, Created: time.Now().UTC()}
+
+	links, err := newSpool("gshort-links")
+	if err != nil {
+		return
+	}
+	defer links.remove()
+	clicks, err := newSpool("gshort-clicks")
+	if err != nil {
+		return
+	}
+	defer clicks.remove()
+	for _, site := range config.Sites() {
+		manifest.Domains = append(manifest.Domains, site.Domain)
+		err = DataBase.Each(ctx, site.MongoDB, func(r *DataBase.Record) error {
+			manifest.Links++
+			return links.enc.Encode(newBackupLink(site.Domain, r))
+		})
+		if err != nil {
+			return
+		}
+		err = DataBase.EachClick(ctx, site.MongoDB, func(c *DataBase.Click) error {
+			manifest.Clicks++
+			return clicks.enc.Encode(newBackupClick(site.Domain, c))
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	settings, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return
+	}
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name string, size int64, r io.Reader) error {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: size, ModTime: manifest.Created, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		return err
+	}
+	linksSize, err := links.rewind()
+	if err != nil {
+		return
+	}
+	clicksSize, err := clicks.rewind()
+	if err != nil {
+		return
+	}
+	if err = add("manifest.json", int64(len(m)), bytes.NewReader(m)); err != nil {
+		return
+	}
+	if err = add("settings.json", int64(len(settings)), bytes.NewReader(settings)); err != nil {
+		return
+	}
+	if err = add("links.jsonl", linksSize, links); err != nil {
+		return
+	}
+	if err = add("clicks.jsonl", clicksSize, clicks); err != nil {
+		return
+	}
+	if err = tw.Close(); err != nil {
+		return
+	}
+	err = gz.Close()
+	return
+}
+
+// A link of a backup, by the domain of the config it was restored to
+type backupKey struct {
+	domain string
+	slug   string
+}
+
+// Counts of a restore
+type restoreResult struct {
+	Restored      int
+	Existing      int // slugs already in the store, left as they were
+	UnknownDomain int // links of domains the config doesn't serve
+	Clicks        int // clicks of the restored links, the ones of other links are skipped
+}
+
+// Restores the links of a backup, and their clicks, into the store of config. Slugs already taken are left untouched.
+// The settings of the backup are written to settings when it's not nil
+func restore(ctx context.Context, config *Config.Config, r io.Reader, settings io.Writer) (manifest backupManifest, result restoreResult, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, result, fmt.Errorf("not a gShort backup: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	first := true
+	restored := make(map[backupKey]bool)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, result, err
+		}
+		if first {
+			if h.Name != "manifest.json" {
+				return manifest, result, fmt.Errorf("not a gShort backup: no manifest")
+			}
+			if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, result, fmt.Errorf("invalid manifest: %v", err)
+			}
+			if manifest.Format != backupFormat || manifest.Version < 1 {
+				return manifest, result, fmt.Errorf("not a gShort backup")
+			}
+			if manifest.Version > backupVersion {
+				return manifest, result, fmt.Errorf("backup version %d is newer than the %d this gShort reads", manifest.Version, backupVersion)
+			}
+			first = false
+			continue
+		}
+
+		switch h.Name {
+		case "settings.json":
+			if settings != nil {
+				if _, err = io.Copy(settings, tr); err != nil {
+					return manifest, result, err
+				}
+			}
+		case "links.jsonl":
+			dec := json.NewDecoder(tr)
+			for dec.More() {
+				var l backupLink
+				if err = dec.Decode(&l); err != nil {
+					return manifest, result, fmt.Errorf("links.jsonl: %v", err)
+				}
+				site := config.Site(l.Domain)
+				if site == nil {
+					result.UnknownDomain++
+					continue
+				}
+				_, err = DataBase.FindByMapping(ctx, site.MongoDB, l.Slug)
+				if err == nil {
+					result.Existing++
+					continue
+				}
+				if err != mongo.ErrNoDocuments {
+					return manifest, result, err
+				}
+				if _, err = DataBase.InsertRecord(ctx, site.MongoDB, l.record()); err != nil {
+					return manifest, result, err
+				}
+				restored[backupKey{site.Domain, l.Slug}] = true
+				result.Restored++
+			}
+		case "clicks.jsonl": // after links.jsonl
+			dec := json.NewDecoder(tr)
+			for dec.More() {
+				var c backupClick
+				if err = dec.Decode(&c); err != nil {
+					return manifest, result, fmt.Errorf("clicks.jsonl: %v", err)
+				}
+				site := config.Site(c.Domain)
+				if site == nil || !restored[backupKey{site.Domain, c.Slug}] {
+					continue
+				}
+				if err = DataBase.InsertClick(ctx, site.MongoDB, c.click()); err != nil {
+					return manifest, result, err
+				}
+				result.Clicks++
+			}
+		}
+	}
+	if first {
+		return manifest, result, fmt.Errorf("not a gShort backup: empty archive")
+	}
+	return manifest, result, nil
+}
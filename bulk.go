@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A link of a bulk request. CSV bodies name the same columns in their header row,
+// only url is required. The slug column of exports is taken as alias
+type bulkRow struct {
+	Url          string          `json:"url"`
+	Alias        string          `json:"alias"` // mapping to use instead of a random one
+	Password     string          `json:"password"`
+	MaxHitCount  int             `json:"maxhitcount"`
+	Expires      string          `json:"expires"`      // RFC 3339 time or 2006-01-02 date (UTC), empty for no expiry
+	Prefix       bool            `json:"prefix"`       // visits can add a path and query to the URL, see destination
+	ForwardQuery *bool           `json:"forwardquery"` // whether visits pass their query on, empty follows ForwardQuery of the config
+	UTMSource    string          `json:"utm_source"`
+	UTMMedium    string          `json:"utm_medium"`
+	UTMCampaign  string          `json:"utm_campaign"`
+	Redirect     string          `json:"redirect"` // one of Config.RedirectTypes, empty follows the config
+	Rules        []DataBase.Rule `json:"rules"`    // only in JSON bodies
+	Geo          *DataBase.Geo   `json:"geo"`      // only in JSON bodies
+	Split        *DataBase.Split `json:"split"`    // only in JSON bodies
+
+	invalid error // set when a CSV column can't be parsed
+}
+
+// What happened to a row of a bulk request, rows are numbered from 1 without the CSV header
+type bulkResult struct {
+	Row     int    `json:"row"`
+	Url     string `json:"url"`
+	Mapping string `json:"mapping,omitempty"` // the short URL
+	Created bool   `json:"created"`           // false when an existing link was returned
+	Error   string `json:"error,omitempty"`
+}
+
+type bulkResponse struct {
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []bulkResult `json:"results"`
+}
+
+var (
+	errInvalidTTL    = errors.New("invalid max hit count")
+	errInvalidExpiry = errors.New("invalid expiry")
+	errInvalidRow    = errors.New("invalid row")
+	errRateLimited   = errors.New("rate limited")
+)
+
+// Handles POST /api/links/bulk: creates every link of a JSON array or a CSV file (Content-Type: text/csv).
+// Rows fail independently, the response has the short URL or the error of each one
+func gShortBulk(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
+	if !authorized(config, th, w, r) {
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var rows []bulkRow
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		rows, err = parseBulkCSV(body)
+	} else {
+		err = json.Unmarshal(body, &rows)
+	}
+	if err != nil {
+		Log.FromContext(r.Context()).Info("rejected bulk request", "error", err)
+		apiError(w, r, th, config, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	max := defaultMaxBulkRows
+	if config.Server != nil && config.Server.MaxBulkRows > 0 {
+		max = config.Server.MaxBulkRows
+	}
+	if len(rows) > max {
+		Log.FromContext(r.Context()).Info("rejected bulk request", "rows", len(rows), "max", max)
+		apiError(w, r, th, config, http.StatusRequestEntityTooLarge, "too_many_rows", max)
+		return
+	}
+
+	lang := th.negotiate(r.Header.Get("Accept-Language"), config.Language)
+	res := bulkResponse{Results: make([]bulkResult, len(rows))}
+	for i, row := range rows {
+		result := bulkResult{Row: i + 1, Url: row.Url}
+		var mapping string
+		var created bool
+		if i > 0 && !allowRow(r) {
+			err = errRateLimited
+		} else {
+			mapping, created, err = bulkCreate(r.Context(), config, row)
+		}
+		if err != nil {
+			id, ok := shortenMessages[err]
+			if !ok {
+				Log.FromContext(r.Context()).Error("error creating link", "row", i+1, "error", err)
+				id = "server_error"
+			}
+			result.Error = th.translate(lang, id)
+			res.Failed++
+		} else {
+			result.Mapping = buildMapping(config, mapping)
+			result.Created = created
+			if created {
+				res.Created++
+			}
+		}
+		res.Results[i] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// Creates the link of a row. Rows with nothing but a URL get the existing link of the URL, like /short
+func bulkCreate(ctx context.Context, config *Config.Config, row bulkRow) (mapping string, created bool, err error) {
+	if row.invalid != nil {
+		return "", false, row.invalid
+	}
+	row.Url = strings.TrimSpace(row.Url)
+	if !isValidUrl(row.Url) {
+		return "", false, errInvalidURL
+	}
+	if row.MaxHitCount < 0 {
+		return "", false, errInvalidTTL
+	}
+	expires, err := parseExpiry(row.Expires)
+	if err != nil {
+		return "", false, errInvalidExpiry
+	}
+
+	row.Redirect = strings.TrimSpace(row.Redirect)
+	if err = checkRedirect(row.Redirect); err != nil {
+		return "", false, err
+	}
+	if err = checkRules(row.Rules); err != nil {
+		return "", false, err
+	}
+	if err = checkGeo(row.Geo); err != nil {
+		return "", false, err
+	}
+	if err = checkSplit(row.Split); err != nil {
+		return "", false, err
+	}
+	utm := &DataBase.UTM{Source: strings.TrimSpace(row.UTMSource), Medium: strings.TrimSpace(row.UTMMedium), Campaign: strings.TrimSpace(row.UTMCampaign)}
+	if *utm == (DataBase.UTM{}) {
+		utm = nil
+	}
+
+	if row.Alias == "" && row.Password == "" && row.MaxHitCount == 0 && expires.IsZero() && !row.Prefix && row.ForwardQuery == nil && utm == nil && row.Redirect == "" && len(row.Rules) == 0 && row.Geo == nil && row.Split == nil {
+		if mapping, err = DataBase.FilterFromURL(ctx, config.MongoDB, row.Url); err == nil {
+			return mapping, false, nil
+		}
+	}
+	record := &DataBase.Record{Url: row.Url, Password: row.Password, MaxHitCount: row.MaxHitCount, ExpiresAt: expires, Prefix: row.Prefix,
+		ForwardQuery: row.ForwardQuery, UTM: utm, Redirect: row.Redirect, Rules: row.Rules, Geo: row.Geo, Split: row.Split}
+	mapping, err = insertLink(ctx, config, record, strings.TrimSpace(row.Alias))
+	return mapping, err == nil, err
+}
+
+// Parses an expiry given as an RFC 3339 time or a date, which expires at its start in UTC.
+// Empty is no expiry, times in the past are rejected
+func parseExpiry(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", s)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, errInvalidExpiry
+	}
+	return t.UTC(), nil
+}
+
+// Parses a CSV body, the header row names the columns of bulkRow in any order
+func parseBulkCSV(body []byte) (rows []bulkRow, err error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, errors.New("no url column")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		row := bulkRow{Url: get("url"), Alias: get("alias"), Password: get("password"), Expires: get("expires"),
+			UTMSource: get("utm_source"), UTMMedium: get("utm_medium"), UTMCampaign: get("utm_campaign"), Redirect: get("redirect")}
+		if row.Alias == "" { // the column of exports
+			row.Alias = get("slug")
+		}
+		if hits := strings.TrimSpace(get("maxhitcount")); hits != "" {
+			if row.MaxHitCount, err = strconv.Atoi(hits); err != nil {
+				row.invalid = errInvalidTTL
+			}
+		}
+		if prefix := strings.TrimSpace(get("prefix")); prefix != "" {
+			if row.Prefix, err = strconv.ParseBool(prefix); err != nil {
+				row.invalid = errInvalidRow
+			}
+		}
+		if forward := strings.TrimSpace(get("forwardquery")); forward != "" {
+			b, err := strconv.ParseBool(forward)
+			if err != nil {
+				row.invalid = errInvalidRow
+			}
+			row.ForwardQuery = &b
+		}
+		rows = append(rows, row)
+	}
+}
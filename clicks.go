@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// What the clicks of a link are counted by in the analytics API, with the name of the clicks without a value
+var clickFields = []struct {
+	field string
+	empty string
+}{
+	{"rule", defaultRule},
+	{"os", "unknown"},
+	{"device", "unknown"},
+	{"browser", "unknown"},
+	{"country", "unknown"},
+	{"variant", "none"},
+}
+
+// The rule of the clicks that went to the URL of the link itself
+const defaultRule = "default"
+
+// Records a visit that was sent to its destination
+func recordClick(ctx context.Context, config *Config.Config, mapping string, p pick, v visitor) error {
+	return DataBase.InsertClick(ctx, config.MongoDB, &DataBase.Click{
+		Mapping: mapping,
+		Rule:    p.Rule,
+		OS:      v.OS,
+		Device:  v.Device,
+		Browser: v.Browser,
+		Country: v.Country,
+		Variant: p.Variant,
+	})
+}
+
+type clickStats struct {
+	Slug   string                    `json:"slug"`
+	Hits   int                       `json:"hits"`   // every visit, recorded or not
+	Clicks int                       `json:"clicks"` // the recorded ones, which the counts are made of
+	By     map[string]map[string]int `json:"by"`     // counts by field and value, eg: by.os.ios
+	// The variants the split of the link has now with their clicks, to compare them
+	Variants []variantClicks `json:"variants,omitempty"`
+}
+
+type variantClicks struct {
+	Name   string `json:"name"`
+	Url    string `json:"url"`
+	Weight int    `json:"weight"`
+	Clicks int    `json:"clicks"`
+}
+
+// Handles GET /api/links/{slug}/clicks: the clicks of a link counted by rule, OS, device, browser, country and variant
+func gShortClicks(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
+	if !authorized(config, th, w, r) {
+		return
+	}
+	slug := mux.Vars(r)["slug"]
+	logger := Log.FromContext(r.Context()).With("mapping", slug)
+	record, err := DataBase.FindByMapping(r.Context(), config.MongoDB, slug)
+	if err == mongo.ErrNoDocuments {
+		apiError(w, r, th, config, http.StatusNotFound, "link_not_found")
+		return
+	}
+	if err != nil {
+		logger.Error("error looking up mapping", "error", err)
+		apiError(w, r, th, config, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	fields := make([]string, len(clickFields))
+	for i, f := range clickFields {
+		fields[i] = f.field
+	}
+	by, err := DataBase.CountClicks(r.Context(), config.MongoDB, slug, fields)
+	if err != nil {
+		logger.Error("error counting clicks", "error", err)
+		apiError(w, r, th, config, http.StatusInternalServerError, "server_error")
+		return
+	}
+	stats := clickStats{Slug: slug, Hits: record.HitCount, By: by}
+	for i, f := range clickFields {
+		counts := by[f.field]
+		if n, ok := counts[""]; ok {
+			delete(counts, "")
+			counts[f.empty] += n
+		}
+		if i == 0 {
+			for _, n := range counts {
+				stats.Clicks += n
+			}
+		}
+	}
+	if split := record.Split; split != nil {
+		for i, v := range split.Variants {
+			name := variantName(v, i)
+			stats.Variants = append(stats.Variants, variantClicks{Name: name, Url: v.Url, Weight: variantWeight(v), Clicks: stats.By["variant"][name]})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"gShort/Config"
+	"gShort/Log"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// Admin commands, run as gshort <command> [flags]. They take the flags of the server too,
+// -config and the overrides, and run against the same database
+var commands = map[string]struct {
+	usage string
+	run   func(args []string) error
+}{
+	"export":  {"Writes every link of a domain with its hit count as CSV or JSON", exportCommand},
+	"import":  {"Imports the links of a YOURLS, Shlink, Kutt or Bitly CSV export", importCommand},
+	"backup":  {"Writes a backup of the links and settings of every domain", backupCommand},
+	"restore": {"Restores a backup into the database of the config", restoreCommand},
+}
+
+func runCommand(name string, args []string) {
+	c, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, the commands are:\n", name)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", n, commands[n].usage)
+		}
+		os.Exit(2)
+	}
+	if err := c.run(args); err != nil {
+		Log.Fatal("error running "+name, "error", err)
+	}
+}
+
+// Parses the flags of a command, fs already has the ones of the command, and loads the config
+func commandConfig(fs *flag.FlagSet, args []string) (*Config.Config, error) {
+	a := Config.NewArgs(fs)
+	_ = fs.Parse(args) // fs exits on errors
+	var config *Config.Config
+	config, err := config.LoadConfigFrom(a.ConfigFile, a.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	if config.Log != nil {
+		if err = Log.Setup(config.Log.Format, config.Log.Level); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// The config of the domain a command was asked to work on
+func commandSite(config *Config.Config, domain string) (*Config.Config, error) {
+	site := config.Site(domain)
+	if site == nil {
+		return nil, fmt.Errorf("%s is not one of the domains served", domain)
+	}
+	return site, nil
+}
+
+// Opens the file a command writes to, stdout when name is empty.
+// Private files can only be read by their owner, even when they existed before
+func createOutput(name string, private bool) (io.WriteCloser, error) {
+	if name == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	if !private {
+		return os.Create(name)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("gshort export", flag.ExitOnError)
+	format := fs.String("format", "csv", "csv or json")
+	output := fs.String("o", "", "File to write the links to, stdout when empty")
+	domain := fs.String("site", "", "Domain whose links are exported, the main one when empty")
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unknown export format %q, use csv or json", *format)
+	}
+	site, err := commandSite(config, *domain)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(*output, false)
+	if err != nil {
+		return err
+	}
+	err = exportLinks(context.Background(), site, *format, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("gshort import", flag.ExitOnError)
+	from := fs.String("from", "", "Shortener the file was exported from: yourls, shlink, kutt or bitly")
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without writing anything")
+	domain := fs.String("site", "", "Domain the links are imported into, the main one when empty")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gshort import -from format [flags] file.csv")
+		fs.PrintDefaults()
+	}
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	site, err := commandSite(config, *domain)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	records, rowErrs, err := readImport(*from, f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	results, err := importRecords(context.Background(), site, records, rowErrs, *dryRun)
+	writeImportReport(os.Stdout, results, *dryRun)
+	return err
+}
+
+func backupCommand(args []string) error {
+	fs := flag.NewFlagSet("gshort backup", flag.ExitOnError)
+	output := fs.String("o", "", "File to write the backup to, stdout when empty")
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(*output, true)
+	if err != nil {
+		return err
+	}
+	manifest, err := backup(context.Background(), config, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	Log.Info("backup written", "links", manifest.Links, "clicks", manifest.Clicks, "domains", len(manifest.Domains))
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("gshort restore", flag.ExitOnError)
+	settingsFile := fs.String("settings", "", "File to write the settings of the backup to, they are not restored when empty")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gshort restore [flags] backup.tar.gz")
+		fs.PrintDefaults()
+	}
+	config, err := commandConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var settings io.WriteCloser
+	if *settingsFile != "" {
+		// never overwrite a config
+		if settings, err = os.OpenFile(*settingsFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			return err
+		}
+	}
+	var w io.Writer
+	if settings != nil {
+		w = settings
+	}
+	manifest, result, err := restore(context.Background(), config, in, w)
+	if settings != nil {
+		if closeErr := settings.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d of %d links from the backup of %s, %d already present, %d for domains not served\n",
+		result.Restored, manifest.Links, manifest.Created.Format(time.RFC3339), result.Existing, result.UnknownDomain)
+	fmt.Printf("restored %d of %d clicks\n", result.Clicks, manifest.Clicks)
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A link as it is exported, passwords are left out.
+// The CSV columns have the same names, all but short_url, hits, protected, disabled and created can be fed back to the bulk API
+type exportedLink struct {
+	Slug         string          `json:"slug"`
+	ShortURL     string          `json:"short_url"`
+	Url          string          `json:"url"`
+	Hits         int             `json:"hits"`
+	MaxHitCount  int             `json:"maxhitcount"`
+	Protected    bool            `json:"protected"`
+	Disabled     bool            `json:"disabled"`
+	Prefix       bool            `json:"prefix"`
+	ForwardQuery *bool           `json:"forwardquery"` // null when it follows the config
+	UTMSource    string          `json:"utm_source"`
+	UTMMedium    string          `json:"utm_medium"`
+	UTMCampaign  string          `json:"utm_campaign"`
+	Redirect     string          `json:"redirect"`        // empty when it follows the config
+	Rules        []DataBase.Rule `json:"rules,omitempty"` // left out of CSV exports
+	Geo          *DataBase.Geo   `json:"geo,omitempty"`   // left out of CSV exports
+	Split        *DataBase.Split `json:"split,omitempty"` // left out of CSV exports
+	Created      string          `json:"created"`         // RFC 3339, empty when unknown
+	Expires      string          `json:"expires"`         // RFC 3339, empty when it never expires
+}
+
+var exportColumns = []string{"slug", "short_url", "url", "hits", "maxhitcount", "protected", "disabled", "prefix",
+	"forwardquery", "utm_source", "utm_medium", "utm_campaign", "redirect", "created", "expires"}
+
+func newExportedLink(config *Config.Config, r *DataBase.Record) exportedLink {
+	date := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	l := exportedLink{
+		Slug:         r.Mapping,
+		ShortURL:     buildMapping(config, r.Mapping),
+		Url:          r.Url,
+		Hits:         r.HitCount,
+		MaxHitCount:  r.MaxHitCount,
+		Protected:    r.Password != "",
+		Disabled:     r.Disabled,
+		Prefix:       r.Prefix,
+		Created:      date(r.CreatedAt),
+		Expires:      date(r.ExpiresAt),
+		ForwardQuery: r.ForwardQuery,
+		Redirect:     r.Redirect,
+		Rules:        r.Rules,
+		Geo:          r.Geo,
+		Split:        r.Split,
+	}
+	if r.UTM != nil {
+		l.UTMSource, l.UTMMedium, l.UTMCampaign = r.UTM.Source, r.UTM.Medium, r.UTM.Campaign
+	}
+	return l
+}
+
+func (l exportedLink) columns() []string {
+	forward := "" // empty follows the config
+	if l.ForwardQuery != nil {
+		forward = strconv.FormatBool(*l.ForwardQuery)
+	}
+	return []string{l.Slug, l.ShortURL, l.Url, strconv.Itoa(l.Hits), strconv.Itoa(l.MaxHitCount),
+		strconv.FormatBool(l.Protected), strconv.FormatBool(l.Disabled), strconv.FormatBool(l.Prefix),
+		forward, l.UTMSource, l.UTMMedium, l.UTMCampaign, l.Redirect, l.Created, l.Expires}
+}
+
+// Writes every link of the domain of config to w, format is csv or json
+func exportLinks(ctx context.Context, config *Config.Config, format string, w io.Writer) error {
+	switch format {
+	case "csv":
+		out := csv.NewWriter(w)
+		if err := out.Write(exportColumns); err != nil {
+			return err
+		}
+		err := DataBase.Each(ctx, config.MongoDB, func(r *DataBase.Record) error {
+			return out.Write(newExportedLink(config, r).columns())
+		})
+		if err != nil {
+			return err
+		}
+		out.Flush()
+		return out.Error()
+	case "json": // streamed, there can be a lot of links
+		separator := "[\n"
+		err := DataBase.Each(ctx, config.MongoDB, func(r *DataBase.Record) error {
+			b, err := json.Marshal(newExportedLink(config, r))
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "%s%s", separator, b)
+			separator = ",\n"
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if separator == "[\n" { // no links
+			_, err = io.WriteString(w, "[]\n")
+		} else {
+			_, err = io.WriteString(w, "\n]\n")
+		}
+		return err
+	}
+	return fmt.Errorf("unknown export format %q, use csv or json", format)
+}
+
+// Handles GET /api/links: every link with its hit count, as JSON or as CSV with ?format=csv
+func gShortExport(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
+	if !authorized(config, th, w, r) {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "json":
+		format = "json"
+		w.Header().Set("Content-Type", "application/json")
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="links.csv"`)
+	default:
+		apiError(w, r, th, config, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	// The status is sent with the first link, errors after that can only be logged
+	out := &writeTracker{Writer: w}
+	if err := exportLinks(r.Context(), config, format, out); err != nil {
+		Log.FromContext(r.Context()).Error("error exporting links", "error", err)
+		if !out.written {
+			w.Header().Del("Content-Disposition")
+			apiError(w, r, th, config, http.StatusInternalServerError, "server_error")
+		}
+	}
+}
+
+// Remembers whether anything was written
+type writeTracker struct {
+	io.Writer
+	written bool
+}
+
+func (t *writeTracker) Write(p []byte) (int, error) {
+	t.written = true
+	return t.Writer.Write(p)
+}
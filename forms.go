@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"gShort/Config"
+	"gShort/GeoIP"
+	"gShort/Log"
+	"gShort/Metrics"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Plain HTML form handlers, the pages work without JavaScript through them.
+// The scripts of the built in pages use the JSON endpoint and the Key header instead
+
+// Message ids of the errors of shorten, insertLink, bulkCreate and linkPatch, anything else is a server error
+var shortenMessages = map[error]string{
+	errInvalidURL:      "invalid_url",
+	errCaptcha:         "captcha_failed",
+	errInvalidAlias:    "invalid_alias",
+	errAliasTaken:      "alias_taken",
+	errInvalidTTL:      "invalid_ttl",
+	errInvalidExpiry:   "invalid_expiry",
+	errInvalidRow:      "invalid_request",
+	errInvalidRedirect: "invalid_redirect",
+	errInvalidRules:    "invalid_rules",
+	errInvalidGeo:      "invalid_geo",
+	errInvalidSplit:    "invalid_split",
+	errRateLimited:     "rate_limited",
+}
+
+// Answers an API request with an error, {"error": "..."} in the language the client prefers. args fill the verbs of the message
+func apiError(w http.ResponseWriter, r *http.Request, th *theme, config *Config.Config, status int, id string, args ...interface{}) {
+	if id == "" {
+		id = "server_error"
+	}
+	lang := th.negotiate(r.Header.Get("Accept-Language"), config.Language)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{th.translate(lang, id, args...)})
+}
+
+// Handles the shorten form of index.html and renders the homepage with the short URL or the error
+func gShortForm(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
+	data := th.page(config, r)
+	if err := r.ParseForm(); err != nil {
+		data.Error = data.T("invalid_request")
+		th.render(w, r, pageIndex, http.StatusBadRequest, data)
+		return
+	}
+	a := gShortPutRequest{
+		Url:      strings.TrimSpace(r.PostForm.Get("url")),
+		Token:    r.PostForm.Get("recaptcha"),
+		Password: r.PostForm.Get("password"),
+	}
+	data.URL = a.Url
+	if hits := strings.TrimSpace(r.PostForm.Get("maxhitcount")); hits != "" {
+		n, err := strconv.Atoi(hits)
+		if err != nil || n < 0 {
+			data.Error = data.T("invalid_ttl")
+			th.render(w, r, pageIndex, http.StatusBadRequest, data)
+			return
+		}
+		a.MaxHitCount = n
+	}
+	if a.Url == "" {
+		data.Error = data.T(shortenMessages[errInvalidURL])
+		th.render(w, r, pageIndex, http.StatusBadRequest, data)
+		return
+	}
+
+	mapping, created, err := shorten(r.Context(), config, a)
+	if err != nil {
+		id, ok := shortenMessages[err]
+		if !ok {
+			id = "server_error"
+		}
+		data.Error = data.T(id)
+		th.render(w, r, pageIndex, shortenStatus(err), data)
+		return
+	}
+	data.Short = buildMapping(config, mapping)
+	data.QRCode = qrURL(config, mapping)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	th.render(w, r, pageIndex, status, data)
+}
+
+// Handles the form of password.html, a correct password redirects to the link
+// and a wrong one renders the password page again with the error
+func gShortUnlock(config *Config.Config, th *theme, geo *GeoIP.DB, w http.ResponseWriter, r *http.Request) {
+	mapping, rest := splitSlug(strings.TrimPrefix(r.URL.EscapedPath(), "/password"))
+	logger := Log.FromContext(r.Context()).With("mapping", mapping)
+	data := th.page(config, r)
+	data.Slug = mapping + rest + withQuery(r)
+	if err := r.ParseForm(); err != nil {
+		data.Error = data.T("invalid_request")
+		th.render(w, r, pagePassword, http.StatusBadRequest, data)
+		return
+	}
+
+	record, ok := lookup(config, th, w, r, mapping)
+	if !ok {
+		return
+	}
+	if rest != "" && !record.Prefix {
+		notFound(config, th, w, r, mapping+rest)
+		return
+	}
+	if record.Password != "" && r.PostForm.Get("password") != record.Password {
+		Metrics.Redirect(Metrics.Unauthorized)
+		data.Error = data.T("invalid_password")
+		th.render(w, r, pagePassword, http.StatusUnauthorized, data)
+		return
+	}
+	v := newVisitor(config, geo, r, mapping)
+	p := route(record, v, func() int { return nextTurn(r.Context(), config, record) })
+	target, ok := destination(config, record, p.Url, rest, r.URL.RawQuery)
+	if !ok {
+		notFound(config, th, w, r, mapping+rest)
+		return
+	}
+
+	Metrics.Redirect(Metrics.Found)
+	stick(config, w, record, p)
+	redirect(config, th, w, r, record, target)
+	ctx, cancel := detached(r.Context())
+	defer cancel()
+	if err := hitCounter(ctx, config, mapping); err != nil {
+		logger.Error("error counting hit", "error", err)
+	}
+	if err := recordClick(ctx, config, mapping, p, v); err != nil {
+		logger.Error("error recording click", "error", err)
+	}
+}
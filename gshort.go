@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"gShort/Config"
 	"gShort/DataBase"
-	"io/ioutil"
-	"log"
+	"gShort/GeoIP"
+	"gShort/Log"
+	"gShort/Metrics"
+	"gShort/Tracing"
 	"net/http"
 	"os"
-	"github.com/someone-stole-my-name/reCAPTCHAv3"
 	"strconv"
+	"strings"
+	"time"
 
-	rice "github.com/GeertJohan/go.rice"
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // This is how are request to the backend looks like
@@ -29,239 +34,477 @@ type gShortGetResponse struct {
 	Url     string `json:"url"`     // 'shorted' url
 	Mapping string `json:"mapping"` // mapping is just the random string associated with that url
 	Password string `json:"password"`
+	QRCode string `json:"qrcode,omitempty"` // URL of the PNG QR code of the mapping
 }
 
 func main() {
-	var (
-		config *Config.Config //json config
-		index  string         // templated index
-		err    error
-	)
-
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") { // gshort <command>
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
 	args := *Config.ParseArgs()
-	config, err = config.LoadConfigFrom(args.ConfigFile)
+	st, err := loadState(args.ConfigFile, args.Overrides)
 	if err != nil {
-		log.Fatalln(err)
+		Log.Fatal("error loading config", "file", args.ConfigFile, "error", err)
 	}
-
-	index, err = buildIndex(config)
-	if err != nil {
-		log.Fatalln(err)
+	current.Store(st)
+	config := st.config // settings read at startup only, requests use live()
+	if config.Log != nil {
+		if err = Log.Setup(config.Log.Format, config.Log.Level); err != nil {
+			Log.Fatal("error setting up logging", "error", err)
+		}
+	}
+	if err = Tracing.Setup(config.Tracing); err != nil {
+		Log.Fatal("error setting up tracing", "error", err)
 	}
 
 	if args.JustTemplate {
 		_ = os.Mkdir("./_templates", 0770)
 
-		f, err := os.Create("./_templates/index.html")
-		if err != nil {
-			log.Fatalln(err)
-		}
-		_, err = f.WriteString(index)
-		if err != nil {
-			fmt.Println(err)
-		}
-		err = f.Close()
-		if err != nil {
-			log.Fatalln(err)
+		for i, site := range config.Sites() {
+			name := "./_templates/index.html"
+			if i > 0 {
+				name = "./_templates/index." + site.Domain + ".html"
+			}
+			f, err := os.Create(name)
+			if err != nil {
+				Log.Fatal("error creating template", "file", name, "error", err)
+			}
+			index, err := st.theme(site).execute(pageIndex, st.theme(site).page(site, nil))
+			if err != nil {
+				Log.Fatal("error rendering template", "file", name, "error", err)
+			}
+			_, err = f.Write(index)
+			if err != nil {
+				Log.Error("error writing template", "file", name, "error", err)
+			}
+			err = f.Close()
+			if err != nil {
+				Log.Fatal("error writing template", "file", name, "error", err)
+			}
 		}
 
 		return
 	}
 
 	router := mux.NewRouter().StrictSlash(true)
+	admin := router
+	if config.AdminListen != "" {
+		admin = mux.NewRouter()
+	}
+	if config.Metrics {
+		admin.Handle("/metrics", Metrics.Handler()).Methods("GET").Name(routeMetrics)
+	}
+	// Probes are reserved paths and skip the domain checks, load balancers use IPs
+	probed := []*mux.Router{router}
+	if admin != router {
+		probed = append(probed, admin)
+	}
+	for _, r := range probed {
+		r.HandleFunc("/healthz", healthz).Methods("GET", "HEAD").Name(routeHealthz)
+		r.HandleFunc("/readyz", readyz).Methods("GET", "HEAD").Name(routeReadyz)
+	}
+
 	router.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
-		if !comingFromDomain(config.Domain, config.Port, r) { // make sure user is coming from configurated domain
-			http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port), http.StatusMovedPermanently)
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, "/"), http.StatusMovedPermanently)
+			return
+		}
+
+		gShortPut(site, st.theme(site), w, r)
+	}).Methods("POST").Name(routeShort)
+
+	// API, for clients with one of the APIKeys
+	router.HandleFunc(pathBulk, func(w http.ResponseWriter, r *http.Request) {
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
+			return
+		}
+
+		gShortBulk(site, st.theme(site), w, r)
+	}).Methods("POST").Name(routeBulk)
+
+	router.HandleFunc(pathLinks, func(w http.ResponseWriter, r *http.Request) {
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
 			return
 		}
 
-		gShortPut(config, w, r)
-	}).Methods("POST")
+		gShortExport(site, st.theme(site), w, r)
+	}).Methods("GET").Name(routeExport)
+
+	router.HandleFunc(pathLinks+"/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
+			return
+		}
+
+		gShortPatch(site, st.theme(site), w, r)
+	}).Methods("PATCH").Name(routeLink)
+
+	router.HandleFunc(pathLinks+"/{slug}/clicks", func(w http.ResponseWriter, r *http.Request) {
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
+			return
+		}
+
+		gShortClicks(site, st.theme(site), w, r)
+	}).Methods("GET").Name(routeClicks)
+
+	// Form posts of the pages, they work without JavaScript
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		st := live()
+		site, ok := siteFor(st.config, r)
+		if !ok { // make sure user is coming from configurated domain
+			http.Redirect(w, r, canonicalURL(site, "/"), http.StatusMovedPermanently)
+			return
+		}
+
+		gShortForm(site, st.theme(site), w, r)
+	}).Methods("POST").Name(routeShortForm)
+
+	router.PathPrefix("/password/").HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			st := live()
+			site, ok := siteFor(st.config, r)
+			if !ok { // make sure user is coming from configurated domain
+				http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
+				return
+			}
+
+			gShortUnlock(site, st.theme(site), st.geo, w, r)
+		}).Methods("POST").Name(routeUnlock)
 
 	router.PathPrefix("/password/").HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			if !comingFromDomain(config.Domain, config.Port, r) { // make sure user is coming from configurated domain
-				http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port)+r.RequestURI, http.StatusMovedPermanently)
+			st := live()
+			site, ok := siteFor(st.config, r)
+			if !ok { // make sure user is coming from configurated domain
+				http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
 				return
 			}
 
-			box := rice.MustFindBox("website")
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			password, _ := box.String("password.html")
-			_, _ = fmt.Fprint(w, password)
+			th := st.theme(site)
+			data := th.page(site, r)
+			data.Slug = strings.TrimPrefix(r.URL.EscapedPath(), "/password/") + withQuery(r)
+			th.render(w, r, pagePassword, http.StatusOK, data)
 			return
-		}).Methods("GET")
+		}).Methods("GET").Name(routePassword)
 
 	router.PathPrefix("/").HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			// index request
-			if !comingFromDomain(config.Domain, config.Port, r) { // make sure user is coming from configurated domain
-				http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port), http.StatusMovedPermanently)
+			st := live()
+			site, ok := siteFor(st.config, r)
+			if !ok { // make sure user is coming from configurated domain
+				http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
 				return
 			}
 
+			th := st.theme(site)
 			if r.RequestURI == "/" {
-				w.Header().Set("Content-Type", "text/html; charset=utf-8")
-				_, _ = fmt.Fprint(w, index)
+				th.render(w, r, pageIndex, http.StatusOK, th.page(site, r))
 				return
 			}
 
 			// requesting something else?
-			box := rice.MustFindBox("website")
-			if boxHasFile(box, r.RequestURI) {
-				http.FileServer(box.HTTPBox()).ServeHTTP(w, r)
-			} else { // if requested file is not in box try to redirect
-				gShortGet(config, w, r) // it will redirect to homepage if not found in db
+			if th.has(r.URL.Path) {
+				http.FileServer(th).ServeHTTP(w, r)
+			} else if qr := st.qr[site.Domain]; qr != nil && isQRPath(r.URL.Path) {
+				gShortQR(site, th, qr, w, r)
+			} else { // if requested file is not in the theme try to redirect
+				gShortGet(site, th, st.geo, w, r) // it will render the 404 page if not found in db
 			}
-		}).Methods("GET")
+		}).Methods("GET").Name(routeSlug)
 
-	// CORS Headers
+	// CORS preflights are answered by securityMiddleware
 	router.PathPrefix("/").HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			if !comingFromDomain(config.Domain, config.Port, r) { // make sure user is coming from configurated domain
-				http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port), http.StatusMovedPermanently)
-				return
-			}
-			w.Header().Set("Access-Control-Allow-Origin", config.Protocol+"://"+config.Domain)
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		}).Methods("OPTIONS")
+			w.Header().Set("Allow", corsMethods)
+			w.WriteHeader(http.StatusNoContent)
+		}).Methods("OPTIONS").Name(routeOptions)
+
+	router.Use(tracingMiddleware, metricsMiddleware, securityMiddleware, rateLimitMiddleware(config))
 
-	ListenAndServe(config, router)
+	go watchConfig(args.ConfigFile, args.Overrides, time.Duration(config.ReloadInterval)*time.Second)
+	ListenAndServe(config, router, admin)
 }
 
-func gShortPut(config *Config.Config, w http.ResponseWriter, r *http.Request) {
+func gShortPut(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
 	var a gShortPutRequest
 
-	reqBody, _ := ioutil.ReadAll(r.Body)
+	reqBody, ok := readBody(w, r)
+	if !ok {
+		return
+	}
 	err := json.Unmarshal(reqBody, &a)
 	if err != nil || len(a.Url) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+		apiError(w, r, th, config, http.StatusBadRequest, "invalid_request")
 		return
 	}
 
-	if !isValidUrl(a.Url) {
-		w.WriteHeader(http.StatusBadRequest)
-		log.Println("Bad URL")
+	mapping, created, err := shorten(r.Context(), config, a)
+	if err != nil {
+		apiError(w, r, th, config, shortenStatus(err), shortenMessages[err])
 		return
 	}
 
+	// Prevent returning stuff like http://localhost/XXXX when port != 80
+	resBody := gShortGetResponse{Url: a.Url, Mapping: buildMapping(config, mapping), QRCode: qrURL(config, mapping)}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(resBody)
+}
+
+var (
+	errInvalidURL   = errors.New("invalid URL")
+	errCaptcha      = errors.New("reCAPTCHA verification failed")
+	errInvalidAlias = errors.New("invalid alias")
+	errAliasTaken   = errors.New("alias already in use")
+)
+
+// Creates the mapping of a URL, or returns the one created before for the same URL (created is then false).
+// Rejected requests get errInvalidURL or errCaptcha, anything else is a server error
+func shorten(ctx context.Context, config *Config.Config, a gShortPutRequest) (mapping string, created bool, err error) {
+	logger := Log.FromContext(ctx)
+	if !isValidUrl(a.Url) {
+		logger.Info("rejected invalid url", "url", a.Url)
+		return "", false, errInvalidURL
+	}
+
 	// If there are reCaptcha keys in the config we check:
 	// if returned token from frontend is valid
 	// if returned hostname matches the domain in config file
 	if len(config.ReCaptcha.SecretKey) > 0 && len(config.ReCaptcha.SiteKey) > 0 {
-		err = reCAPTCHAv3.ValidateReCaptcha(config.ReCaptcha.SecretKey, a.Token, config.Domain)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			log.Printf("Invalid reCaptcha: %v\n", err)
-			return
+		if err = verifyReCaptcha(ctx, config.ReCaptcha.SecretKey, a.Token, config.Domain); err != nil {
+			Metrics.CaptchaFailure()
+			logger.Info("rejected invalid reCaptcha", "error", err)
+			return "", false, errCaptcha
 		}
 	}
 
-	if len(a.Password) == 0 || a.MaxHitCount == 0 { // Password protected urls will return false bypassing the check, always create a new mapping
-		mappingInDB, err := DataBase.FilterFromURL(config.MongoDB, a.Url)
+	if len(a.Password) == 0 && a.MaxHitCount == 0 { // links with a password or a limit always get a new mapping
+		mappingInDB, err := DataBase.FilterFromURL(ctx, config.MongoDB, a.Url)
 		if err == nil {
-			mapping := buildMapping(config, mappingInDB)
-			resBody := gShortGetResponse{Url: a.Url, Mapping: mapping}
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(resBody)
-			return
+			return mappingInDB, false, nil
 		}
 	}
 
-	// Generate a new random string
-	mapping := generateStringWithCharset(config.RandomStringGenerator.Length, config.RandomStringGenerator.Charset)
-	for {
-		_, err = DataBase.FilterFromMapping(config.MongoDB, mapping) // Check if that string is already is DB
-		if err != nil {
-			break // If it is not break the loop
-		}
-		mapping = generateStringWithCharset(config.RandomStringGenerator.Length, config.RandomStringGenerator.Charset)
+	mapping, err = insertLink(ctx, config, &DataBase.Record{Url: a.Url, Password: a.Password, MaxHitCount: a.MaxHitCount}, "")
+	if err != nil {
+		logger.Error("error writing to database", "error", err)
+		return "", false, err
 	}
+	return mapping, true, nil
+}
 
-	_, err = DataBase.Insert(config.MongoDB, a.Url, mapping, a.Password, a.MaxHitCount)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Printf("Error writing to database: %v", err)
-		return
+// Stores a new link under alias, or under a new random mapping when alias is empty.
+// Fails with errInvalidAlias or errAliasTaken when the alias can't be used
+func insertLink(ctx context.Context, config *Config.Config, record *DataBase.Record, alias string) (mapping string, err error) {
+	if alias != "" {
+		if !validAlias(alias) {
+			return "", errInvalidAlias
+		}
+		_, err = DataBase.FindByMapping(ctx, config.MongoDB, alias)
+		if err == nil {
+			return "", errAliasTaken
+		}
+		if err != mongo.ErrNoDocuments {
+			return "", err
+		}
+		mapping = alias
+	} else {
+		// Generate a new random string
+		mapping = generateStringWithCharset(config.RandomStringGenerator.Length, config.RandomStringGenerator.Charset)
+		for {
+			_, err = DataBase.FindByMapping(ctx, config.MongoDB, mapping) // Check if that string is already is DB
+			if err != nil {
+				break // If it is not break the loop
+			}
+			Metrics.SlugCollision()
+			mapping = generateStringWithCharset(config.RandomStringGenerator.Length, config.RandomStringGenerator.Charset)
+		}
 	}
 
-	// Prevent returning stuff like http://localhost/XXXX when port != 80
-	mapped := buildMapping(config, mapping)
+	r := *record
+	r.Mapping = mapping
+	r.Alias = alias != ""
+	_, err = DataBase.InsertRecord(ctx, config.MongoDB, &r)
+	return mapping, err
+}
 
-	resBody := gShortGetResponse{Url: a.Url, Mapping: mapped}
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(resBody)
+// The HTTP status for an error of shorten or insertLink
+func shortenStatus(err error) int {
+	switch err {
+	case errInvalidURL, errCaptcha, errInvalidAlias, errInvalidRedirect, errInvalidRules, errInvalidGeo, errInvalidSplit:
+		return http.StatusBadRequest
+	case errAliasTaken:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
 }
 
-func gShortGet(config *Config.Config, w http.ResponseWriter, r *http.Request) {
-	mapping := trimLeftChar(r.RequestURI)
-	log.Printf("Requested %v\n", mapping)
+func gShortGet(config *Config.Config, th *theme, geo *GeoIP.DB, w http.ResponseWriter, r *http.Request) {
+	mapping, rest := splitSlug(r.URL.EscapedPath())
+	logger := Log.FromContext(r.Context()).With("mapping", mapping)
+	logger.Debug("mapping requested")
 	var a gShortGetResponse
 
-	b, p, err := DataBase.IsPasswordProtected(config.MongoDB, mapping)
-	reqBody, _ := ioutil.ReadAll(r.Body)
+	reqBody, ok := readBody(w, r)
+	if !ok {
+		return
+	}
 	if len(reqBody) > 0 {
-		err = json.Unmarshal(reqBody, &a)
+		err := json.Unmarshal(reqBody, &a)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 	}
 
+	record, ok := lookup(config, th, w, r, mapping)
+	if !ok {
+		return
+	}
+	if rest != "" && !record.Prefix { // only prefix links take a path after the slug
+		notFound(config, th, w, r, mapping+rest)
+		return
+	}
+
+	b := len(record.Password) > 0
 	if b && len(r.Header.Get("Key")) == 0 {
-		log.Printf("Password protected mapping %v and no password provided, redirecting to password page.", mapping)
-		http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port)+"/password/"+mapping, http.StatusFound)
+		logger.Debug("password protected mapping and no password provided, redirecting to password page")
+		Metrics.Redirect(Metrics.PasswordPrompt)
+		http.Redirect(w, r, canonicalURL(config, "/password/"+mapping+rest+withQuery(r)), http.StatusFound)
 		return
 	}
 
-	if r.Header.Get("Key") != p {
+	if r.Header.Get("Key") != record.Password {
+		Metrics.Redirect(Metrics.Unauthorized)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	if b && r.Header.Get("Key") == p {
-		mapsTo, err := DataBase.FilterFromMapping(config.MongoDB, mapping)
-		w.Header().Set("Location", mapsTo)
-		if err != nil {
-			log.Printf("Error: %v", err)
-			http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port),
-				http.StatusFound)
-			return
-		}
-		w.WriteHeader(http.StatusAccepted)
-		err = hitCounter(config, mapping)
-		if err != nil {
-			log.Printf("Error in hitCounter: %v", err)
-		}
+	v := newVisitor(config, geo, r, mapping)
+	p := route(record, v, func() int { return nextTurn(r.Context(), config, record) })
+	target, ok := destination(config, record, p.Url, rest, r.URL.RawQuery)
+	if !ok {
+		notFound(config, th, w, r, mapping+rest)
 		return
 	}
+	if len(record.Rules) > 0 {
+		w.Header().Add("Vary", "User-Agent, Accept-Language")
+	}
+	if record.Geo != nil || record.Split != nil { // caches can't vary by IP, nor pick variants
+		w.Header().Set("Cache-Control", "private")
+	}
 
-	mapsTo, err := DataBase.FilterFromMapping(config.MongoDB, mapping)
+	Metrics.Redirect(Metrics.Found)
+	stick(config, w, record, p)
+	redirect(config, th, w, r, record, target)
+	ctx, cancel := detached(r.Context())
+	defer cancel()
+	err := hitCounter(ctx, config, mapping)
 	if err != nil {
-		log.Printf("Error: %v", err)
-		http.Redirect(w, r, config.Protocol+"://"+config.Domain+":"+strconv.Itoa(config.Port),
-			http.StatusFound)
-		return
+		logger.Error("error counting hit", "error", err)
+	}
+	if err = recordClick(ctx, config, mapping, p, v); err != nil {
+		logger.Error("error recording click", "error", err)
 	}
+	return
+}
 
-	http.Redirect(w, r, mapsTo, http.StatusFound)
-	err = hitCounter(config, mapping)
+// Looks up the record of a mapping that can be visited. When there is none the
+// response is written, with the 404, disabled or expired page, and ok is false
+func lookup(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request, mapping string) (record *DataBase.Record, ok bool) {
+	logger := Log.FromContext(r.Context()).With("mapping", mapping)
+	data := th.page(config, r)
+	data.Slug = mapping
+	record, err := DataBase.FindByMapping(r.Context(), config.MongoDB, mapping)
+	if err == mongo.ErrNoDocuments {
+		notFound(config, th, w, r, mapping)
+		return nil, false
+	}
 	if err != nil {
-		log.Printf("Error in hitCounter: %v", err)
+		logger.Error("error looking up mapping", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
 	}
-	return
+
+	if record.Disabled {
+		Metrics.Redirect(Metrics.Disabled)
+		th.render(w, r, pageDisabled, http.StatusForbidden, data)
+		return nil, false
+	}
+	if record.Expired(time.Now()) {
+		Metrics.Redirect(Metrics.Expired)
+		th.render(w, r, pageExpired, http.StatusGone, data)
+		return nil, false
+	}
+	return record, true
 }
 
-func ListenAndServe(config *Config.Config, router *mux.Router) {
-	log.Printf("Using DB: %v\n", config.MongoDB.DataBase)
-	log.Printf("Using Col: %v\n", config.MongoDB.Collection)
+// Answers a visit to a link that doesn't exist with the 404 page
+func notFound(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request, slug string) {
+	Metrics.Redirect(Metrics.NotFound)
+	Log.FromContext(r.Context()).Info("mapping not found", "mapping", slug)
+	data := th.page(config, r)
+	data.Slug = slug
+	th.render(w, r, pageNotFound, http.StatusNotFound, data)
+}
+
+func ListenAndServe(config *Config.Config, router *mux.Router, admin *mux.Router) {
+	Log.Info("using database", "database", config.MongoDB.DataBase, "collection", config.MongoDB.Collection)
 	// Since config.Port is used in many places ...
 	port := os.Getenv("PORT") // heroku
-	if port != "" {           // if env exists
-		log.Printf("Listening on: %v", port)
-		log.Fatal(http.ListenAndServe(":"+port, router))
-	} else {
-		log.Printf("Listening on: %v", config.Port)
-		log.Fatal(http.ListenAndServe(":"+strconv.Itoa(config.Port), router))
+	if port == "" {           // if env doesn't exist
+		port = strconv.Itoa(config.Port)
+	}
+
+	var servers []*http.Server
+	if admin != router {
+		Log.Info("admin endpoints listening", "addr", config.AdminListen)
+		servers = append(servers, newServer(config, config.AdminListen, admin))
+	}
+
+	if config.TLS == nil {
+		Log.Info("listening", "port", port)
+		serve(config, append(servers, newServer(config, ":"+port, router))...)
+		return
 	}
+
+	certs, err := newCertReloader(config.TLS)
+	if err != nil {
+		Log.Fatal("error loading certificates", "error", err)
+	}
+	interval := time.Duration(config.TLS.ReloadInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go certs.watch(interval)
+
+	var plain http.Handler = router
+	if config.TLS.RedirectHTTP { // Protocol is https, see Config.Validate
+		plain = redirectToHTTPS()
+	}
+
+	secure := newServer(config, ":"+strconv.Itoa(config.TLS.HTTPSPort()), router)
+	secure.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+
+	Log.Info("listening", "port", port)
+	Log.Info("listening", "port", config.TLS.HTTPSPort(), "tls", true)
+	serve(config, append(servers, newServer(config, ":"+port, plain), secure)...)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"gShort/DataBase"
+	"net/http"
+	"time"
+)
+
+const defaultReadyTimeout = 2 * time.Second
+
+type healthCheck struct {
+	Status  string `json:"status"`
+	Latency int64  `json:"latency_ms"`
+	Error   string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+func writeHealth(w http.ResponseWriter, status int, res healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// Liveness probe, answers as long as the process can serve requests
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readiness probe, checks that the database answers within ReadyTimeout
+func readyz(w http.ResponseWriter, r *http.Request) {
+	config := live().config
+	timeout := defaultReadyTimeout
+	if config.Server != nil {
+		timeout = seconds(config.Server.ReadyTimeout, defaultReadyTimeout)
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	res := healthResponse{Status: "ok", Checks: make(map[string]healthCheck)}
+	start := time.Now()
+	check := healthCheck{Status: "ok"}
+	if err := DataBase.Ping(ctx, config.MongoDB); err != nil {
+		check.Status = "error"
+		check.Error = err.Error()
+		res.Status = "error"
+	}
+	check.Latency = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	res.Checks["mongodb"] = check
+
+	status := http.StatusOK
+	if res.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, res)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message catalogs live in the locales directory of a theme, one JSON object of
+// message id to message per language, eg: locales/es.json. A catalog in the theme
+// directory takes the place of the built in one for the same language
+const (
+	localesDir      = "locales"
+	defaultLanguage = "en"
+)
+
+type catalog map[string]string
+
+// Loads the built in catalogs and then the ones of the theme directory
+func (t *theme) loadCatalogs() error {
+	t.catalogs = make(map[string]catalog)
+	var names []string
+	err := t.box.Walk(localesDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			names = append(names, path.Base(filepath.ToSlash(p)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if t.dir != "" {
+		infos, err := ioutil.ReadDir(filepath.Join(t.dir, localesDir))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+	}
+
+	for _, name := range names {
+		if path.Ext(name) != ".json" {
+			continue
+		}
+		lang := strings.ToLower(strings.TrimSuffix(name, ".json"))
+		if _, ok := t.catalogs[lang]; ok {
+			continue
+		}
+		f, err := t.open(localesDir + "/" + name)
+		if err != nil {
+			return err
+		}
+		c := catalog{}
+		err = json.NewDecoder(f).Decode(&c)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s/%s: %v", localesDir, name, err)
+		}
+		t.catalogs[lang] = c
+	}
+	if _, ok := t.catalogs[defaultLanguage]; !ok {
+		return fmt.Errorf("no %s/%s.json catalog", localesDir, defaultLanguage)
+	}
+	return t.checkVerbs()
+}
+
+// Formatting verbs of a message, %% is not one
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]*)?[a-zA-Z%]`)
+
+func countVerbs(msg string) (n int) {
+	for _, v := range verbPattern.FindAllString(msg, -1) {
+		if v != "%%" {
+			n++
+		}
+	}
+	return
+}
+
+// Checks that the messages of every catalog have as many formatting verbs as the built in
+// default catalog, the arguments of translate would be missing or left over otherwise
+func (t *theme) checkVerbs() error {
+	b, err := t.box.Bytes(localesDir + "/" + defaultLanguage + ".json")
+	if err != nil {
+		return err
+	}
+	builtin := catalog{}
+	if err = json.Unmarshal(b, &builtin); err != nil {
+		return err
+	}
+	for lang, c := range t.catalogs {
+		for id, msg := range c {
+			ref, ok := builtin[id]
+			if !ok {
+				continue
+			}
+			if n, want := countVerbs(msg), countVerbs(ref); n != want {
+				return fmt.Errorf("%s/%s.json: %q has %d formatting verbs, it needs %d", localesDir, lang, id, n, want)
+			}
+		}
+	}
+	return nil
+}
+
+// The languages of an Accept-Language header in lower case, the preferred ones first
+func acceptedLanguages(acceptLanguage string) []string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{lang, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	langs := make([]string, len(choices))
+	for i, c := range choices {
+		langs[i] = c.lang
+	}
+	return langs
+}
+
+// Picks the language of the catalog that suits an Accept-Language header best,
+// fallback is used when the header names none of the catalogs
+func (t *theme) negotiate(acceptLanguage string, fallback string) string {
+	for _, lang := range acceptedLanguages(acceptLanguage) {
+		if _, ok := t.catalogs[lang]; ok {
+			return lang
+		}
+		if i := strings.Index(lang, "-"); i > 0 { // es-ES is served the es catalog
+			if _, ok := t.catalogs[lang[:i]]; ok {
+				return lang[:i]
+			}
+		}
+	}
+	fallback = strings.ToLower(fallback)
+	if _, ok := t.catalogs[fallback]; ok {
+		return fallback
+	}
+	return defaultLanguage
+}
+
+// Translates a message id, messages missing in a catalog are taken from the default one.
+// args fill the verbs of the message like fmt.Sprintf
+func (t *theme) translate(lang string, id string, args ...interface{}) string {
+	msg, ok := t.catalogs[lang][id]
+	if !ok {
+		msg, ok = t.catalogs[defaultLanguage][id]
+	}
+	if !ok {
+		msg = id
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"gShort/Config"
+	"gShort/DataBase"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Columns of the CSV exports of another shortener, by field. Names are matched ignoring
+// case, spaces, _ and -, the first one found is used
+type importFormat struct {
+	slug     []string
+	shortURL []string // the slug is taken from its path when there is no slug column
+	url      []string
+	hits     []string
+	created  []string
+	expires  []string
+}
+
+var importFormats = map[string]importFormat{
+	"yourls": { // table export of yourls_url
+		slug:    []string{"keyword"},
+		url:     []string{"url"},
+		hits:    []string{"clicks"},
+		created: []string{"timestamp"},
+	},
+	"shlink": {
+		slug:     []string{"shortcode"},
+		shortURL: []string{"shorturl"},
+		url:      []string{"longurl"},
+		hits:     []string{"visits", "visitscount"},
+		created:  []string{"createdat", "datecreated"},
+		expires:  []string{"validuntil"},
+	},
+	"kutt": {
+		slug:     []string{"address"},
+		shortURL: []string{"link", "shortlink"},
+		url:      []string{"target"},
+		hits:     []string{"visitcount", "visits"},
+		created:  []string{"createdat"},
+		expires:  []string{"expirein", "expiresat"},
+	},
+	"bitly": {
+		shortURL: []string{"bitlink", "link", "shorturl"},
+		url:      []string{"longurl"},
+		hits:     []string{"clicks", "totalclicks"},
+		created:  []string{"createdat", "created", "datecreated"},
+	},
+}
+
+// Layouts of the dates found in exports, times without a zone are taken as UTC
+var importDates = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+	"1/2/2006",
+}
+
+// What happened to a row of an import
+const (
+	importCreated  = "imported"
+	importExisting = "existing" // the slug already maps to the same URL
+	importConflict = "conflict" // the slug is taken by another URL
+	importInvalid  = "invalid"
+)
+
+type importResult struct {
+	Row     int // line of the file, the header is line 1
+	Slug    string
+	Url     string
+	Outcome string
+	Detail  string // why a row was not imported
+}
+
+// Reads the rows of an export made by the shortener format, records have no namespace
+func readImport(format string, in io.Reader) (records []*DataBase.Record, errs []error, err error) {
+	f, ok := importFormats[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown import format %q, use yourls, shlink, kutt or bitly", format)
+	}
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[normalizeColumn(name)] = i
+	}
+	column := func(names []string) int {
+		for _, n := range names {
+			if i, ok := columns[n]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	slugCol, shortCol, urlCol := column(f.slug), column(f.shortURL), column(f.url)
+	hitsCol, createdCol, expiresCol := column(f.hits), column(f.created), column(f.expires)
+	if urlCol < 0 || (slugCol < 0 && shortCol < 0) {
+		return nil, nil, fmt.Errorf("not a %s export: no column for the slug or the URL in %v", format, header)
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, errs, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		get := func(i int) string {
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		r := &DataBase.Record{Mapping: get(slugCol), Url: get(urlCol)}
+		var rowErr error
+		if r.Mapping == "" {
+			r.Mapping = slugFromShortURL(get(shortCol))
+		}
+		if hits := get(hitsCol); hits != "" {
+			if r.HitCount, err = strconv.Atoi(strings.Replace(hits, ",", "", -1)); err != nil {
+				rowErr = fmt.Errorf("invalid hit count %q", hits)
+			}
+		}
+		if r.CreatedAt, err = parseImportDate(get(createdCol)); err != nil {
+			rowErr = err
+		}
+		if r.ExpiresAt, err = parseImportDate(get(expiresCol)); err != nil {
+			rowErr = err
+		}
+		records = append(records, r)
+		errs = append(errs, rowErr)
+	}
+}
+
+func normalizeColumn(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "\ufeff"))
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.TrimSpace(name))
+}
+
+// The slug of a short URL like https://bit.ly/abc or bit.ly/abc
+func slugFromShortURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
+
+func parseImportDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range importDates {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+var errDuplicateSlug = errors.New("the slug is used by an earlier row")
+
+// Imports the records read by readImport into the domain of config, slugs are kept as they are.
+// Nothing is written when dryRun is set, the results are what would have happened
+func importRecords(ctx context.Context, config *Config.Config, records []*DataBase.Record, rowErrs []error, dryRun bool) (results []importResult, err error) {
+	seen := make(map[string]bool)
+	for i, r := range records {
+		result := importResult{Row: i + 2, Slug: r.Mapping, Url: r.Url}
+		result.Outcome, result.Detail = checkImportRow(r, rowErrs[i], seen)
+		if result.Outcome != "" {
+			results = append(results, result)
+			continue
+		}
+
+		existing, err := DataBase.FindByMapping(ctx, config.MongoDB, r.Mapping)
+		switch {
+		case err == nil && existing.Url == r.Url:
+			result.Outcome = importExisting
+		case err == nil:
+			result.Outcome, result.Detail = importConflict, "already maps to "+existing.Url
+		case err != mongo.ErrNoDocuments:
+			return results, err
+		default:
+			result.Outcome = importCreated
+			if !dryRun {
+				if _, err = DataBase.InsertRecord(ctx, config.MongoDB, r); err != nil {
+					return results, err
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// The outcome of a row that can't be imported whatever the database has, and why. Empty for the others.
+// seen has the slugs of the earlier rows, slugs are case sensitive like the links
+func checkImportRow(r *DataBase.Record, rowErr error, seen map[string]bool) (outcome string, detail string) {
+	switch {
+	case rowErr != nil:
+		outcome, detail = importInvalid, rowErr.Error()
+	case !validAlias(r.Mapping):
+		outcome, detail = importInvalid, "unsupported slug, gShort slugs have letters, digits, - and _"
+	case !isValidUrl(r.Url):
+		outcome, detail = importInvalid, "invalid URL"
+	case seen[r.Mapping]:
+		outcome, detail = importConflict, errDuplicateSlug.Error()
+	}
+	seen[r.Mapping] = true
+	return
+}
+
+// Writes the rows that were not imported and a summary
+func writeImportReport(w io.Writer, results []importResult, dryRun bool) {
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Outcome]++
+		if r.Outcome == importConflict || r.Outcome == importInvalid {
+			fmt.Fprintf(w, "line %d: %s %q -> %s: %s\n", r.Row, r.Outcome, r.Slug, r.Url, r.Detail)
+		}
+	}
+	verb := "imported"
+	if dryRun {
+		verb = "would import"
+	}
+	fmt.Fprintf(w, "%s %d links, %d already present, %d conflicts, %d invalid\n",
+		verb, counts[importCreated], counts[importExisting], counts[importConflict], counts[importInvalid])
+}
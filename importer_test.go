@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Exports in the layouts the shorteners write them: phpMyAdmin's CSV of the yourls_url table,
+// the export of the Shlink web client, Kutt links as its API lists them and Bitly's link export
+func TestReadImport(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.UTC()
+	}
+	type row struct {
+		slug, url string
+		hits      int
+		created   time.Time
+		expires   time.Time
+		invalid   bool
+	}
+	tests := []struct {
+		format string
+		export string
+		rows   []row
+	}{
+		{"yourls", `"keyword","url","title","timestamp","ip","clicks"
+"ozh","http://ozh.org/","Ozh","2009-08-09 18:50:31","127.0.0.1","12"
+"yourls","https://yourls.org/","YOURLS","2009-08-09 18:51:02","127.0.0.1","0"
+"bad","https://example.com/","Bad","yesterday","127.0.0.1","many"
+`, []row{
+			{"ozh", "http://ozh.org/", 12, date("2009-08-09T18:50:31Z"), time.Time{}, false},
+			{"yourls", "https://yourls.org/", 0, date("2009-08-09T18:51:02Z"), time.Time{}, false},
+			{"bad", "https://example.com/", 0, time.Time{}, time.Time{}, true},
+		}},
+		{"shlink", `createdAt,domain,shortCode,shortUrl,longUrl,title,tags,visits
+2023-05-10T12:30:00+02:00,,abc12,https://s.test/abc12,https://example.com/a?b=1,Example,"foo,bar",41
+2023-06-01T08:00:00+00:00,s.test,custom,https://s.test/custom,https://example.com/custom,,,0
+`, []row{
+			{"abc12", "https://example.com/a?b=1", 41, date("2023-05-10T10:30:00Z"), time.Time{}, false},
+			{"custom", "https://example.com/custom", 0, date("2023-06-01T08:00:00Z"), time.Time{}, false},
+		}},
+		{"shlink", `Short URL,Long URL,Date created,Visits count,Valid until
+https://s.test/xyz,https://example.com/xyz,2022-01-02 03:04:05,7,2030-01-01T00:00:00+00:00
+`, []row{
+			{"xyz", "https://example.com/xyz", 7, date("2022-01-02T03:04:05Z"), date("2030-01-01T00:00:00Z"), false},
+		}},
+		{"kutt", `address,target,link,visit_count,created_at,expire_in
+docs,https://example.com/docs,https://kutt.it/docs,1204,2023-04-01T10:00:00.000Z,2024-04-01T10:00:00.000Z
+,https://example.com/nolink,https://kutt.it/nolink,3,2023-04-02T10:00:00.000Z,
+`, []row{
+			{"docs", "https://example.com/docs", 1204, date("2023-04-01T10:00:00Z"), date("2024-04-01T10:00:00Z"), false},
+			{"nolink", "https://example.com/nolink", 3, date("2023-04-02T10:00:00Z"), time.Time{}, false},
+		}},
+		{"bitly", "\ufeff" + `Bitlink,Long URL,Title,Date Created,Total Clicks,Tags
+bit.ly/3xYzAbC,https://example.com/spring?utm_source=x,Spring sale,1/15/2024 14:03,"1,204",
+https://bit.ly/promo,https://example.com/promo,,2024-02-01,0,promo
+`, []row{
+			{"3xYzAbC", "https://example.com/spring?utm_source=x", 1204, date("2024-01-15T14:03:00Z"), time.Time{}, false},
+			{"promo", "https://example.com/promo", 0, date("2024-02-01T00:00:00Z"), time.Time{}, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			records, errs, err := readImport(tt.format, strings.NewReader(tt.export))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != len(tt.rows) || len(errs) != len(tt.rows) {
+				t.Fatalf("%d records and %d errors, want %d", len(records), len(errs), len(tt.rows))
+			}
+			for i, want := range tt.rows {
+				r := records[i]
+				if r.Mapping != want.slug || r.Url != want.url || r.HitCount != want.hits {
+					t.Errorf("row %d: %s %s %d, want %s %s %d", i+2, r.Mapping, r.Url, r.HitCount, want.slug, want.url, want.hits)
+				}
+				if (errs[i] != nil) != want.invalid {
+					t.Errorf("row %d: error %v", i+2, errs[i])
+				}
+				if !want.invalid && (!r.CreatedAt.Equal(want.created) || !r.ExpiresAt.Equal(want.expires)) {
+					t.Errorf("row %d: created %v expires %v, want %v %v", i+2, r.CreatedAt, r.ExpiresAt, want.created, want.expires)
+				}
+			}
+		})
+	}
+}
+
+func TestReadImportInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		export string
+	}{
+		{"unknown format", "tinyurl", "url\nhttps://example.com/\n"},
+		{"empty", "yourls", ""},
+		{"no slug column", "yourls", "url,clicks\nhttps://example.com/,1\n"},
+		{"no URL column", "bitly", "Bitlink,Clicks\nbit.ly/abc,1\n"},
+		{"another shortener", "kutt", "keyword,url,clicks\nozh,http://ozh.org/,12\n"},
+		{"broken quotes", "yourls", "keyword,url\n\"ozh,http://ozh.org/\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := readImport(tt.format, strings.NewReader(tt.export)); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+// Rows are checked before the database, slugs that only differ in case are different links
+func TestCheckImportRow(t *testing.T) {
+	records, errs, err := readImport("bitly", strings.NewReader(`Bitlink,Long URL,Total Clicks
+bit.ly/AbC,https://example.com/upper,1
+bit.ly/abc,https://example.com/lower,2
+bit.ly/AbC,https://example.com/again,3
+bit.ly/a.b,https://example.com/dot,4
+bit.ly/ok,example.com/file,5
+bit.ly/js,javascript:alert(1),6
+bit.ly/many,https://example.com/many,lots
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "", importConflict, importInvalid, importInvalid, importInvalid, importInvalid}
+	seen := make(map[string]bool)
+	for i, r := range records {
+		if got, detail := checkImportRow(r, errs[i], seen); got != want[i] {
+			t.Errorf("%s: %q (%s), want %q", r.Mapping, got, detail, want[i])
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The settings of a link PATCH /api/links/{slug} can change, the ones left out are kept
+type linkPatch struct {
+	Redirect *string          `json:"redirect"` // one of Config.RedirectTypes, empty to follow the config
+	Rules    *[]DataBase.Rule `json:"rules"`    // replace the rules of the link, [] removes them
+	Geo      *DataBase.Geo    `json:"geo"`      // replace the geo rules of the link, {} removes them
+	Split    *DataBase.Split  `json:"split"`    // replace the variants of the link, {} removes them
+}
+
+var errInvalidRedirect = errors.New("invalid redirect type")
+
+// Checks the settings of the patch, the errors are the ones of bulkCreate
+func (p linkPatch) validate() error {
+	if p.Redirect != nil {
+		if err := checkRedirect(*p.Redirect); err != nil {
+			return err
+		}
+	}
+	if p.Rules != nil {
+		if err := checkRules(*p.Rules); err != nil {
+			return err
+		}
+	}
+	if err := checkGeo(p.Geo); err != nil {
+		return err
+	}
+	if p.Split != nil && len(p.Split.Variants) > 0 {
+		if err := checkSplit(p.Split); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes the settings of record that the patch has, it must have been validated
+func (p linkPatch) apply(record *DataBase.Record) {
+	if p.Redirect != nil {
+		record.Redirect = *p.Redirect
+	}
+	if p.Rules != nil {
+		record.Rules = *p.Rules
+		if len(record.Rules) == 0 {
+			record.Rules = nil
+		}
+	}
+	if p.Geo != nil {
+		record.Geo = p.Geo
+		if len(p.Geo.Countries) == 0 && p.Geo.Fallback == "" {
+			record.Geo = nil
+		}
+	}
+	if p.Split != nil {
+		record.Split = p.Split
+		if len(p.Split.Variants) == 0 {
+			record.Split = nil
+		}
+	}
+}
+
+// Checks the redirect type of a link, empty follows the config
+func checkRedirect(s string) error {
+	if s != "" && !Config.ValidRedirect(s) {
+		return errInvalidRedirect
+	}
+	return nil
+}
+
+// Handles PATCH /api/links/{slug}: changes the settings of a link and answers with the link, as it is exported
+func gShortPatch(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request) {
+	if !authorized(config, th, w, r) {
+		return
+	}
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var patch linkPatch
+	if err := json.Unmarshal(body, &patch); err != nil {
+		apiError(w, r, th, config, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if err := patch.validate(); err != nil {
+		apiError(w, r, th, config, http.StatusBadRequest, shortenMessages[err])
+		return
+	}
+
+	slug := mux.Vars(r)["slug"]
+	record, err := DataBase.FindByMapping(r.Context(), config.MongoDB, slug)
+	if err == nil {
+		patch.apply(record)
+		err = record.Update(r.Context(), config.MongoDB)
+	}
+	if err == mongo.ErrNoDocuments {
+		apiError(w, r, th, config, http.StatusNotFound, "link_not_found")
+		return
+	}
+	if err != nil {
+		Log.FromContext(r.Context()).Error("error updating link", "mapping", slug, "error", err)
+		apiError(w, r, th, config, http.StatusInternalServerError, "server_error")
+		return
+	}
+	Log.FromContext(r.Context()).Info("link updated", "mapping", slug)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newExportedLink(config, record))
+}
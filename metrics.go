@@ -0,0 +1,63 @@
+package main
+
+import (
+	"gShort/Metrics"
+	"net/http"
+	"time"
+)
+
+// Route labels for the requests served by the slug route
+const (
+	routeIndex    = "index"
+	routeStatic   = "static"
+	routeRedirect = "redirect"
+	routeQRCode   = "qrcode"
+)
+
+// Keeps the status code and body size written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Names the route that serves r, the catch-all slug route is split in
+// the homepage, static theme files and redirects
+func routeLabel(r *http.Request) string {
+	name := routeName(r)
+	if name != routeSlug {
+		return name
+	}
+	if r.RequestURI == "/" {
+		return routeIndex
+	}
+	st := live()
+	if site, _ := siteFor(st.config, r); st.theme(site).has(r.URL.Path) {
+		return routeStatic
+	}
+	if isQRPath(r.URL.Path) {
+		return routeQRCode
+	}
+	return routeRedirect
+}
+
+// Records the count, status and latency of every request per route
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		Metrics.Request(routeLabel(r), recorder.status, time.Since(start))
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"gShort/Config"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// How the client reached us, as reported by the trusted proxies in front of gShort
+type forwarded struct {
+	client string // client IP
+	host   string // Host header sent by the client, empty if unknown
+	proto  string // protocol used by the client, empty if unknown
+}
+
+// Works out the client side of a request.
+// Forwarding headers are only honoured when the request comes from a trusted proxy,
+// the client is then the rightmost hop that is not a trusted proxy
+func forwardedFrom(config *Config.Config, r *http.Request) (f forwarded) {
+	f.client, _, _ = net.SplitHostPort(r.RemoteAddr)
+	if f.client == "" {
+		f.client = r.RemoteAddr
+	}
+	ip := net.ParseIP(f.client)
+	if ip == nil || !config.IsTrustedProxy(ip) {
+		return
+	}
+
+	if elements := parseForwarded(r.Header["Forwarded"]); len(elements) > 0 {
+		for i := len(elements) - 1; i >= 0; i-- {
+			hop := net.ParseIP(elements[i]["for"])
+			if hop == nil {
+				break
+			}
+			f.client = hop.String()
+			f.host = elements[i]["host"]
+			f.proto = elements[i]["proto"]
+			if !config.IsTrustedProxy(hop) {
+				break
+			}
+		}
+		return
+	}
+
+	hops := headerList(r.Header["X-Forwarded-For"])
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			break
+		}
+		f.client = hop.String()
+		if !config.IsTrustedProxy(hop) {
+			break
+		}
+	}
+	if hosts := headerList(r.Header["X-Forwarded-Host"]); len(hosts) > 0 {
+		f.host = hosts[len(hosts)-1]
+	}
+	if protos := headerList(r.Header["X-Forwarded-Proto"]); len(protos) > 0 {
+		f.proto = strings.ToLower(protos[len(protos)-1])
+	}
+	return
+}
+
+// Returns the IP of the client that made the request
+func clientIP(config *Config.Config, r *http.Request) string {
+	return forwardedFrom(config, r).client
+}
+
+// Splits comma separated header values
+func headerList(values []string) (list []string) {
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return
+}
+
+// Parses RFC 7239 Forwarded headers into one map per element.
+// Quotes, IPv6 brackets and ports are removed from the "for" parameter
+func parseForwarded(values []string) (elements []map[string]string) {
+	for _, element := range headerList(values) {
+		params := make(map[string]string)
+		for _, pair := range strings.Split(element, ";") {
+			i := strings.Index(pair, "=")
+			if i < 0 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(pair[:i]))
+			value := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+			if key == "for" {
+				if host, _, err := net.SplitHostPort(value); err == nil {
+					value = host
+				}
+				value = strings.Trim(value, "[]")
+			}
+			if key == "proto" {
+				value = strings.ToLower(value)
+			}
+			params[key] = value
+		}
+		elements = append(elements, params)
+	}
+	return
+}
+
+// Returns the config of the domain the request was made for.
+// ok is false when the request wasn't made for a served domain over Protocol, site is then
+// the domain the client should be redirected to. Hostnames without a port match any port
+// and the protocol is only checked when a trusted proxy reported it
+func siteFor(config *Config.Config, r *http.Request) (site *Config.Config, ok bool) {
+	f := forwardedFrom(config, r)
+	host := f.host
+	if host == "" {
+		host = r.Host
+	}
+	site = config
+	for _, s := range config.Sites() {
+		if hostMatches(s, host) {
+			site, ok = s, true
+			break
+		}
+	}
+	if f.proto != "" && f.proto != config.Protocol {
+		ok = false
+	}
+	return
+}
+
+func hostMatches(site *Config.Config, host string) bool {
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, accepted := range append([]string{site.Domain}, site.Hosts...) {
+		accepted = strings.ToLower(accepted)
+		if accepted == host || accepted == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds the public URL of path, the port is left out when it's the protocol default
+func canonicalURL(config *Config.Config, path string) string {
+	host := config.Domain
+	port := config.PublicPort()
+	if !(config.Protocol == "http" && port == 80) && !(config.Protocol == "https" && port == 443) && port != 0 {
+		host = net.JoinHostPort(config.Domain, strconv.Itoa(port))
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return config.Protocol + "://" + host + path
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"gShort/QRCode"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QR code settings of a domain, parsed when the config is loaded
+type qrSettings struct {
+	level   QRCode.Level
+	style   QRCode.Style
+	maxSize int
+}
+
+// Parses the QR code settings of site, the logo is read from th unless it's an absolute path.
+// Returns nil when QR codes are off for the site
+func loadQRSettings(site *Config.Config, th *theme) (*qrSettings, error) {
+	c := site.QRCode
+	if c == nil {
+		return nil, nil
+	}
+	s := &qrSettings{maxSize: c.MaxSize}
+	s.style.Size = c.Size
+	var err error
+	if s.level, err = QRCode.ParseLevel(c.Level); err != nil {
+		return nil, err
+	}
+	if s.style.Foreground, err = QRCode.ParseColor(c.Foreground); err != nil {
+		return nil, err
+	}
+	if s.style.Background, err = QRCode.ParseColor(c.Background); err != nil {
+		return nil, err
+	}
+	if c.Logo == "" {
+		return s, nil
+	}
+
+	var f http.File
+	if filepath.IsAbs(c.Logo) {
+		f, err = os.Open(c.Logo)
+	} else {
+		f, err = th.open(path.Clean("/" + c.Logo))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("QRCode.Logo: %v", err)
+	}
+	defer f.Close()
+	if s.style.Logo, _, err = image.Decode(f); err != nil {
+		return nil, fmt.Errorf("QRCode.Logo: %s: %v", c.Logo, err)
+	}
+	if s.level < QRCode.Quartile { // the logo hides part of the code
+		s.level = QRCode.Quartile
+	}
+	return s, nil
+}
+
+// Formats QR codes are served in, by extension
+var qrFormats = map[string]string{
+	".png": "image/png",
+	".svg": "image/svg+xml",
+}
+
+// Whether p asks for the QR code of a short link
+func isQRPath(p string) bool {
+	_, ok := qrFormats[path.Ext(p)]
+	return ok && strings.Count(p, "/") == 1
+}
+
+// The URL of the PNG QR code of a mapping, empty when QR codes are off
+func qrURL(config *Config.Config, mapping string) string {
+	if config.QRCode == nil {
+		return ""
+	}
+	return buildMapping(config, mapping) + ".png"
+}
+
+// Serves /{slug}.png and /{slug}.svg, the QR code of the short URL of slug.
+// ?size= asks for another size in pixels, up to MaxSize
+func gShortQR(config *Config.Config, th *theme, qr *qrSettings, w http.ResponseWriter, r *http.Request) {
+	ext := path.Ext(r.URL.Path)
+	mapping := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ext)
+	logger := Log.FromContext(r.Context()).With("mapping", mapping)
+
+	_, err := DataBase.FindByMapping(r.Context(), config.MongoDB, mapping)
+	if err == mongo.ErrNoDocuments {
+		data := th.page(config, r)
+		data.Slug = mapping
+		th.render(w, r, pageNotFound, http.StatusNotFound, data)
+		return
+	}
+	if err != nil {
+		logger.Error("error looking up mapping", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	style := qr.style
+	if s := r.URL.Query().Get("size"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 21 || size > qr.maxSize {
+			apiError(w, r, th, config, http.StatusBadRequest, "invalid_qr_size")
+			return
+		}
+		style.Size = size
+	}
+
+	code, err := QRCode.Encode([]byte(buildMapping(config, mapping)), qr.level)
+	if err != nil {
+		logger.Error("error encoding QR code", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if ext == ".svg" {
+		err = code.SVG(&buf, style)
+	} else {
+		err = code.PNG(&buf, style)
+	}
+	if err != nil {
+		logger.Error("error rendering QR code", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", qrFormats[ext])
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"gShort/Config"
+	"gShort/Log"
+	"gShort/RateLimit"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Route names, used to pick the rate limit policy and metric labels of a request
+const (
+	routeShort     = "short"
+	routeShortForm = "short_form"
+	routePassword  = "password"
+	routeUnlock    = "unlock"
+	routeSlug      = "slug"
+	routeOptions   = "options"
+	routeMetrics   = "metrics"
+	routeHealthz   = "healthz"
+	routeReadyz    = "readyz"
+	routeBulk      = "bulk"
+	routeExport    = "export"
+	routeLink      = "link"
+	routeClicks    = "clicks"
+)
+
+// Returns a middleware that rate limits link creation, redirects and password attempts
+// according to config.RateLimit, limits are only read at startup. Clients are identified by API key or IP
+func rateLimitMiddleware(config *Config.Config) mux.MiddlewareFunc {
+	if config.RateLimit == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	var (
+		create   = RateLimit.New(config.RateLimit, config.MongoDB, "create", config.RateLimit.Create)
+		redirect = RateLimit.New(config.RateLimit, config.MongoDB, "redirect", config.RateLimit.Redirect)
+		password = RateLimit.New(config.RateLimit, config.MongoDB, "password", config.RateLimit.Password)
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var limiter RateLimit.Limiter
+			switch routeLabel(r) {
+			case routeShort, routeShortForm, routeBulk:
+				limiter = create
+			case routeUnlock:
+				limiter = password
+			case routeRedirect:
+				if len(r.Header.Get("Key")) > 0 { // password attempt from password.html
+					limiter = password
+				} else {
+					limiter = redirect
+				}
+			}
+			if limiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := clientKey(live().config, r)
+			allowed, retryAfter, err := limiter.Allow(r.Context(), key)
+			if err != nil { // don't take the site down with the limiter
+				Log.FromContext(r.Context()).Error("error in rate limiter", "error", err)
+			} else if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			if routeLabel(r) == routeBulk {
+				r = r.WithContext(context.WithValue(r.Context(), rowLimiterKey{}, rowLimiter{limiter, key}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type rowLimiterKey struct{}
+
+// The limiter and client of a bulk request, its rows are charged one by one
+type rowLimiter struct {
+	limiter RateLimit.Limiter
+	key     string
+}
+
+// Charges a row of a bulk request but the first, which was charged with the request itself,
+// to the create limit of the client. Rows are allowed when there is no limit or it fails
+func allowRow(r *http.Request) bool {
+	l, ok := r.Context().Value(rowLimiterKey{}).(rowLimiter)
+	if !ok {
+		return true
+	}
+	allowed, _, err := l.limiter.Allow(r.Context(), l.key)
+	if err != nil {
+		Log.FromContext(r.Context()).Error("error in rate limiter", "error", err)
+		return true
+	}
+	return allowed
+}
+
+func routeName(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	return route.GetName()
+}
+
+// Identifies the client by its API key if it sent a known one, otherwise by IP
+func clientKey(config *Config.Config, r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		for _, k := range config.APIKeys {
+			if k == key {
+				return "key:" + key
+			}
+		}
+	}
+	return "ip:" + clientIP(config, r)
+}
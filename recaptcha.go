@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gShort/Tracing"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Where tokens are verified, tests point it to a local server
+var reCaptchaEndpoint = "https://www.google.com/recaptcha/api/siteverify"
+
+var reCaptchaClient = &http.Client{Timeout: 10 * time.Second}
+
+var errReCaptcha = errors.New("failed reCaptcha")
+
+// Checks a reCAPTCHA v3 token with Google, it must be valid and issued for domain.
+// The request carries the trace of ctx in its traceparent header
+func verifyReCaptcha(ctx context.Context, secret, token, domain string) error {
+	ctx, span := Tracing.Start(ctx, "recaptcha verify", Tracing.KindClient)
+	defer span.End()
+	form := url.Values{"secret": {secret}, "response": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reCaptchaEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	Tracing.Inject(ctx, req.Header)
+	resp, err := reCaptchaClient.Do(req)
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success  bool   `json:"success"`
+		Hostname string `json:"hostname"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err == nil && (!result.Success || result.Hostname != domain) {
+		err = errReCaptcha
+	}
+	span.SetError(err)
+	return err
+}
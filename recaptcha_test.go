@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"gShort/Config"
+	"gShort/Tracing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyReCaptcha(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer collector.Close()
+	if err := Tracing.Setup(&Config.Tracing{Endpoint: collector.URL}); err != nil {
+		t.Fatal(err)
+	}
+	defer Tracing.Shutdown(context.Background())
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var answer, traceparent string
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.PostFormValue("secret") != "s3cret" || r.PostFormValue("response") != "token" {
+			t.Errorf("form %v", r.PostForm)
+		}
+		_, _ = w.Write([]byte(answer))
+	}))
+	defer google.Close()
+	defer func(endpoint string) { reCaptchaEndpoint = endpoint }(reCaptchaEndpoint)
+	reCaptchaEndpoint = google.URL
+
+	tests := []struct {
+		name   string
+		answer string
+		err    error
+	}{
+		{"valid", `{"success": true, "score": 0.9, "hostname": "short.example"}`, nil},
+		{"other domain", `{"success": true, "score": 0.9, "hostname": "evil.example"}`, errReCaptcha},
+		{"invalid token", `{"success": false, "error-codes": ["invalid-input-response"]}`, errReCaptcha},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, traceparent = tt.answer, ""
+			r := httptest.NewRequest(http.MethodPost, "/short", nil)
+			r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			ctx, span := Tracing.Start(Tracing.Extract(r.Context(), r), "POST /short", Tracing.KindServer)
+			defer span.End()
+			if err := verifyReCaptcha(ctx, "s3cret", "token", "short.example"); err != tt.err {
+				t.Errorf("error %v, want %v", err, tt.err)
+			}
+			if !strings.HasPrefix(traceparent, "00-"+traceID+"-") || strings.Contains(traceparent, "00f067aa0ba902b7") {
+				t.Errorf("sent traceparent %q, want a child span of the trace %s", traceparent, traceID)
+			}
+		})
+	}
+	answer = "not json"
+	if err := verifyReCaptcha(context.Background(), "s3cret", "token", "short.example"); err == nil {
+		t.Error("no error for an invalid answer")
+	}
+}
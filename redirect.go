@@ -0,0 +1,46 @@
+package main
+
+import (
+	"gShort/Config"
+	"gShort/DataBase"
+	"net/http"
+	"strconv"
+)
+
+// How record redirects, its own redirect type or the one of the config
+func redirectType(config *Config.Config, record *DataBase.Record) string {
+	if Config.ValidRedirect(record.Redirect) {
+		return record.Redirect
+	}
+	return config.Redirect
+}
+
+// Whether the visits to record can stop working or go somewhere else, browsers then
+// must not keep its permanent redirects
+func changing(record *DataBase.Record) bool {
+	return record.MaxHitCount > 0 || !record.ExpiresAt.IsZero() || len(record.Rules) > 0 || record.Geo != nil || record.Split != nil
+}
+
+// Sends the visitor of a link to target the way the link redirects, see Config.RedirectTypes.
+// Form posts get the refresh page instead of a redirect status: the form-action of the CSP
+// applies to the redirects that follow a form and target is on another origin
+func redirect(config *Config.Config, th *theme, w http.ResponseWriter, r *http.Request, record *DataBase.Record, target string) {
+	kind := redirectType(config, record)
+	if r.Method == http.MethodPost && kind != "interstitial" {
+		kind = "refresh"
+	}
+	switch kind {
+	case "refresh", "interstitial":
+		data := th.page(config, r)
+		data.Slug = record.Mapping
+		data.Target = target
+		data.Interstitial = kind == "interstitial"
+		th.render(w, r, pageRedirect, http.StatusOK, data)
+	default:
+		status, _ := strconv.Atoi(kind)
+		if (status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect) && changing(record) {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		http.Redirect(w, r, target, status)
+	}
+}
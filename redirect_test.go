@@ -0,0 +1,73 @@
+package main
+
+import (
+	"gShort/Config"
+	"gShort/DataBase"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	th, err := loadTheme("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config.Config{Domain: "short.example", Protocol: "https", Redirect: "302", ReCaptcha: &Config.ReCaptcha{}}
+	target := "https://example.com/landing?a=1&b=2"
+	form := url.Values{"password": {"secret"}}.Encode()
+
+	tests := []struct {
+		name     string
+		method   string
+		redirect string
+		hits     int // MaxHitCount of the link
+		status   int
+		location bool   // whether the response has a Location header
+		body     string // in the body of the response
+		cache    string // Cache-Control of the response
+	}{
+		{"302 visit", http.MethodGet, "", 0, http.StatusFound, true, "", ""},
+		{"301 visit", http.MethodGet, "301", 0, http.StatusMovedPermanently, true, "", ""},
+		{"301 one-time visit", http.MethodGet, "301", 1, http.StatusMovedPermanently, true, "", "no-store"},
+		{"308 one-time visit", http.MethodGet, "308", 1, http.StatusPermanentRedirect, true, "", "no-store"},
+		{"302 one-time visit", http.MethodGet, "302", 1, http.StatusFound, true, "", ""},
+		{"refresh visit", http.MethodGet, "refresh", 0, http.StatusOK, false, `http-equiv="refresh"`, ""},
+		{"302 unlock", http.MethodPost, "", 0, http.StatusOK, false, `http-equiv="refresh"`, ""},
+		{"308 unlock", http.MethodPost, "308", 0, http.StatusOK, false, `http-equiv="refresh"`, ""},
+		{"interstitial unlock", http.MethodPost, "interstitial", 0, http.StatusOK, false, `class="button"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body *strings.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader(form)
+			} else {
+				body = strings.NewReader("")
+			}
+			r := httptest.NewRequest(tt.method, "/password/promo", body)
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			record := &DataBase.Record{Mapping: "promo", Url: target, Redirect: tt.redirect, MaxHitCount: tt.hits}
+			redirect(config, th, w, r, record, target)
+
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Location"); (got != "") != tt.location || tt.location && got != target {
+				t.Errorf("Location %q, want one: %v", got, tt.location)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.cache {
+				t.Errorf("Cache-Control %q, want %q", got, tt.cache)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body has no %s:\n%s", tt.body, w.Body)
+			}
+			if !tt.location && !strings.Contains(w.Body.String(), `href="https://example.com/landing?a=1&amp;b=2"`) {
+				t.Errorf("body doesn't link to the target:\n%s", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"gShort/Config"
+	"gShort/GeoIP"
+	"gShort/Log"
+	"gShort/Metrics"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// What requests are served with. A reload builds a new state and swaps it in one go,
+// requests in flight keep the one they started with
+type state struct {
+	config *Config.Config
+	themes map[string]*theme      // theme of every domain
+	qr     map[string]*qrSettings // QR code settings of every domain, nil when off
+	geo    *GeoIP.DB              // nil without a GeoIP database
+}
+
+var current atomic.Value // *state
+
+// The state requests should be served with, load it once per request
+func live() *state {
+	return current.Load().(*state)
+}
+
+// Loads the config, the theme of every domain and the GeoIP database
+func loadState(file string, overrides map[string]string) (*state, error) {
+	var config *Config.Config
+	config, err := config.LoadConfigFrom(file, overrides)
+	if err != nil {
+		return nil, err
+	}
+	s := &state{config: config, themes: make(map[string]*theme), qr: make(map[string]*qrSettings)}
+	loaded := make(map[string]*theme) // domains often share a theme
+	for _, site := range config.Sites() {
+		t, ok := loaded[site.Theme]
+		if !ok {
+			t, err = loadTheme(site.Theme)
+			if err != nil {
+				return nil, fmt.Errorf("error loading theme of %s: %v", site.Domain, err)
+			}
+			loaded[site.Theme] = t
+		}
+		if _, ok := t.catalogs[strings.ToLower(site.Language)]; !ok && site.Language != "" {
+			return nil, fmt.Errorf("no catalog for the language %q of %s in its theme", site.Language, site.Domain)
+		}
+		s.themes[site.Domain] = t
+		if s.qr[site.Domain], err = loadQRSettings(site, t); err != nil {
+			return nil, fmt.Errorf("error loading QR code settings of %s: %v", site.Domain, err)
+		}
+	}
+	if config.GeoIP != "" {
+		if s.geo, err = GeoIP.Open(config.GeoIP); err != nil {
+			return nil, fmt.Errorf("error loading GeoIP database: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// The theme of a domain
+func (s *state) theme(site *Config.Config) *theme {
+	return s.themes[site.Domain]
+}
+
+// Settings that are only read at startup, changing them needs a restart
+func restartRequired(prev *Config.Config, next *Config.Config) (changed []string) {
+	settings := []struct {
+		name       string
+		prev, next interface{}
+	}{
+		{"Port", prev.Port, next.Port},
+		{"AdminListen", prev.AdminListen, next.AdminListen},
+		{"Metrics", prev.Metrics, next.Metrics},
+		{"ReloadInterval", prev.ReloadInterval, next.ReloadInterval},
+		{"TLS", prev.TLS, next.TLS},
+		{"Server", prev.Server, next.Server},
+		{"RateLimit", prev.RateLimit, next.RateLimit},
+		{"Tracing", prev.Tracing, next.Tracing},
+	}
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.prev, s.next) {
+			changed = append(changed, s.name)
+		}
+	}
+	return
+}
+
+// Keeps the settings only read at startup as they are in prev on every site of next,
+// so URLs are still built for the listeners that are running
+func keepStartupSettings(prev *Config.Config, next *Config.Config) {
+	for _, site := range next.Sites() {
+		site.Port = prev.Port
+		site.AdminListen = prev.AdminListen
+		site.Metrics = prev.Metrics
+		site.ReloadInterval = prev.ReloadInterval
+		site.TLS = prev.TLS
+		site.Server = prev.Server
+		site.RateLimit = prev.RateLimit
+		site.Tracing = prev.Tracing
+	}
+}
+
+// Loads the config again and swaps it in, an invalid config is refused and the current one kept
+func reload(file string, overrides map[string]string) error {
+	next, err := loadState(file, overrides)
+	if err != nil {
+		Metrics.ConfigReload(false)
+		return err
+	}
+	if next.config.Log != nil {
+		_ = Log.Setup(next.config.Log.Format, next.config.Log.Level) // already validated
+	}
+	if changed := restartRequired(live().config, next.config); len(changed) > 0 {
+		Log.Warn("some changes only apply after a restart, keeping the current values", "settings", strings.Join(changed, ","))
+		keepStartupSettings(live().config, next.config)
+	}
+	current.Store(next)
+	Metrics.ConfigReload(true)
+	return nil
+}
+
+// Reloads the config on SIGHUP and when the config file changes, which is checked every interval.
+// A zero interval only reloads on SIGHUP
+func watchConfig(file string, overrides map[string]string, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var (
+		tick    <-chan time.Time
+		modTime time.Time
+	)
+	if file != "" && interval > 0 {
+		modTime, _ = lastModified(file)
+		tick = time.Tick(interval)
+	}
+	for {
+		var trigger string
+		select {
+		case <-hup:
+			trigger = "signal"
+		case <-tick:
+			trigger = "file"
+		}
+		t, err := lastModified(file)
+		if trigger == "file" && (err != nil || !t.After(modTime)) {
+			continue
+		}
+		modTime = t
+
+		if err := reload(file, overrides); err != nil {
+			Log.Error("error reloading config, keeping the current one", "trigger", trigger, "file", file, "error", err)
+			continue
+		}
+		Log.Info("reloaded config", "trigger", trigger, "file", file)
+	}
+}
+
+func lastModified(file string) (time.Time, error) {
+	if file == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"gShort/Config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Hosts the built in pages load scripts, styles and fonts from
+var (
+	cdnSources     = []string{"https://cdnjs.cloudflare.com", "https://cdn.jsdelivr.net", "https://code.jquery.com"}
+	captchaSources = []string{"https://www.google.com/recaptcha/", "https://www.gstatic.com/recaptcha/"}
+	defaultHeaders = []string{"Content-Type", "Key", "X-API-Key"}
+	defaultMaxAge  = 600
+	corsMethods    = "GET, POST, PATCH, OPTIONS"
+	exposedHeaders = "Location, Retry-After, " + requestIDHeader
+)
+
+type nonceKey struct{}
+
+// The CSP nonce of a request, inline scripts of the pages must carry it
+func nonceFrom(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Sets the security and CORS headers of every response and answers CORS preflights
+func securityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := live().config
+		site, _ := siteFor(config, r)
+		sec := site.Security
+		if sec == nil {
+			sec = &Config.Security{}
+		}
+
+		nonce := newNonce()
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if policy := contentSecurityPolicy(site, sec, nonce); policy != "" {
+			h.Set("Content-Security-Policy", policy)
+		}
+		if len(sec.FrameAncestors) == 1 && sec.FrameAncestors[0] == "'none'" {
+			h.Set("X-Frame-Options", "DENY") // for browsers without frame-ancestors
+		}
+		if sec.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", sec.ReferrerPolicy)
+		}
+		if value := hstsValue(site, sec); value != "" && (r.TLS != nil || forwardedFrom(config, r).proto == "https") {
+			h.Set("Strict-Transport-Security", value)
+		}
+
+		if cors(site, w, r) {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce)))
+	})
+}
+
+// The Content-Security-Policy of site, the built in one allows what the built in pages need
+func contentSecurityPolicy(site *Config.Config, sec *Config.Security, nonce string) string {
+	switch sec.ContentSecurityPolicy {
+	case "-":
+		return ""
+	case "":
+	default:
+		return strings.Replace(sec.ContentSecurityPolicy, "{nonce}", nonce, -1)
+	}
+
+	sources := func(s ...string) string {
+		return strings.Join(append(s, sec.ExtraSources...), " ")
+	}
+	ancestors := sec.FrameAncestors
+	if len(ancestors) == 0 {
+		ancestors = []string{"'none'"}
+	}
+	origin := strings.TrimSuffix(canonicalURL(site, "/"), "/")
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + sources(append([]string{"'self'", "'nonce-" + nonce + "'"}, append(cdnSources, captchaSources...)...)...),
+		"style-src " + sources(append([]string{"'self'", "'unsafe-inline'"}, cdnSources...)...),
+		"font-src 'self' data: " + strings.Join(cdnSources, " "),
+		"img-src " + sources("'self'", "data:"),
+		"connect-src " + sources("'self'", origin),
+		"frame-src " + strings.Join(captchaSources, " "),
+		"form-action 'self' " + origin,
+		"frame-ancestors " + strings.Join(ancestors, " "),
+		"base-uri 'self'",
+		"object-src 'none'",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// The Strict-Transport-Security value of site, empty when HSTS is off.
+// The HSTS settings of TLS are used when Security has none
+func hstsValue(site *Config.Config, sec *Config.Security) string {
+	maxAge, subDomains, preload := sec.HSTSMaxAge, sec.HSTSIncludeSubDomains, sec.HSTSPreload
+	if maxAge <= 0 && site.TLS != nil {
+		maxAge, subDomains, preload = site.TLS.HSTSMaxAge, site.TLS.HSTSIncludeSubDomains, site.TLS.HSTSPreload
+	}
+	if maxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(maxAge)
+	if subDomains {
+		value += "; includeSubDomains"
+	}
+	if preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// Sets the CORS headers for requests from allowed origins. Preflights are answered
+// here and true is returned, the request needs no further handling then
+func cors(site *Config.Config, w http.ResponseWriter, r *http.Request) bool {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		return false
+	}
+
+	c := site.CORS
+	if c == nil {
+		c = &Config.CORS{}
+	}
+	allowed := c.AllowedOrigins
+	if len(allowed) == 0 {
+		allowed = siteOrigins(site)
+	}
+	ok := false
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", exposedHeaders)
+		return false
+	}
+
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultHeaders
+	}
+	maxAge := c.MaxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+	h.Set("Access-Control-Allow-Methods", corsMethods)
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	h.Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// The origins pages of site are served from: its canonical URL and its Hosts
+func siteOrigins(site *Config.Config) []string {
+	origins := []string{strings.TrimSuffix(canonicalURL(site, "/"), "/")}
+	for _, host := range site.Hosts {
+		origins = append(origins, site.Protocol+"://"+host)
+	}
+	return origins
+}
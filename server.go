@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"gShort/Tracing"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Default Config.Server settings
+const (
+	defaultReadTimeout       = 10 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	defaultMaxHeaderBytes    = 64 << 10
+	defaultMaxBodyBytes      = 64 << 10
+	defaultMaxBulkBytes      = 4 << 20
+	defaultMaxBulkRows       = 1000
+)
+
+func seconds(s int, def time.Duration) time.Duration {
+	if s <= 0 {
+		return def
+	}
+	return time.Duration(s) * time.Second
+}
+
+// Returns a server for addr with the configured timeouts and limits
+func newServer(config *Config.Config, addr string, handler http.Handler) *http.Server {
+	c := config.Server
+	if c == nil {
+		c = &Config.Server{}
+	}
+	maxHeaderBytes := c.MaxHeaderBytes
+	if maxHeaderBytes <= 0 {
+		maxHeaderBytes = defaultMaxHeaderBytes
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           requestID(accessLog(limitBody(config, handler))),
+		ReadTimeout:       seconds(c.ReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: seconds(c.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      seconds(c.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       seconds(c.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// Caps the size of every request body, bulk requests have their own limit
+func limitBody(config *Config.Config, next http.Handler) http.Handler {
+	max, bulk := int64(defaultMaxBodyBytes), int64(defaultMaxBulkBytes)
+	if config.Server != nil && config.Server.MaxBodyBytes > 0 {
+		max = config.Server.MaxBodyBytes
+	}
+	if config.Server != nil && config.Server.MaxBulkBytes > 0 {
+		bulk = config.Server.MaxBulkBytes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countedBody{ReadCloser: r.Body, limit: max}
+		if r.URL.Path == pathBulk {
+			body.limit = bulk
+		}
+		r = r.WithContext(context.WithValue(r.Context(), countedBodyKey{}, body))
+		r.Body = http.MaxBytesReader(w, body, body.limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type countedBodyKey struct{}
+
+// Counts the bytes http.MaxBytesReader reads from a request body. It reads one past the limit
+// before it fails, so the body was too large when more than limit were read
+type countedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// Reads the request body, answering 413 when it is over the limit and 400 when it can't be read
+func readBody(w http.ResponseWriter, r *http.Request) (body []byte, ok bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if b, ok := r.Context().Value(countedBodyKey{}).(*countedBody); ok && b.read > b.limit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		Log.FromContext(r.Context()).Warn("error reading request body", "error", err)
+		return nil, false
+	}
+	return body, true
+}
+
+// Runs the servers, serving TLS when they have a TLSConfig, until one of them fails or the process gets SIGINT/SIGTERM.
+// On a signal the servers stop accepting connections and in-flight requests, hit counting
+// included, get up to ShutdownTimeout to finish
+func serve(config *Config.Config, servers ...*http.Server) {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				errs <- err
+			}
+		}(server)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		Log.Fatal("server failed", "error", err)
+	case sig := <-signals:
+		Log.Info("shutting down", "signal", sig.String())
+	}
+
+	timeout := defaultShutdownTimeout
+	if config.Server != nil {
+		timeout = seconds(config.Server.ShutdownTimeout, defaultShutdownTimeout)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.Shutdown(ctx); err != nil {
+				Log.Error("error shutting down", "addr", server.Addr, "error", err)
+			}
+			done <- struct{}{}
+		}(server)
+	}
+	for range servers {
+		<-done
+	}
+	DataBase.Close(ctx)
+	Tracing.Shutdown(ctx)
+	Log.Info("bye")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"gShort/Config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A body that fails after its content, like a client that goes away
+type brokenBody struct {
+	io.Reader
+}
+
+func (b brokenBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		err = errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestReadBody(t *testing.T) {
+	config := &Config.Config{Server: &Config.Server{MaxBodyBytes: 10, MaxBulkBytes: 20}}
+	handler := limitBody(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := readBody(w, r); ok {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	tests := []struct {
+		name   string
+		path   string
+		body   io.Reader
+		status int
+	}{
+		{"empty", "/short", strings.NewReader(""), http.StatusOK},
+		{"under the limit", "/short", strings.NewReader("123456789"), http.StatusOK},
+		{"at the limit", "/short", strings.NewReader("1234567890"), http.StatusOK},
+		{"over the limit", "/short", strings.NewReader("12345678901"), http.StatusRequestEntityTooLarge},
+		{"far over the limit", "/short", strings.NewReader(strings.Repeat("x", 1<<20)), http.StatusRequestEntityTooLarge},
+		{"bulk under its limit", pathBulk, strings.NewReader(strings.Repeat("x", 20)), http.StatusOK},
+		{"bulk over its limit", pathBulk, strings.NewReader(strings.Repeat("x", 21)), http.StatusRequestEntityTooLarge},
+		{"broken under the limit", "/short", brokenBody{strings.NewReader("12345")}, http.StatusBadRequest},
+		{"broken at the limit", "/short", brokenBody{strings.NewReader("1234567890")}, http.StatusBadRequest},
+		{"broken over the limit", "/short", brokenBody{strings.NewReader("12345678901")}, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			r.Body = ioutil.NopCloser(tt.body)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/Log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// How the variants of a split take turns, weighted is the default
+var rotations = []string{"weighted", "roundrobin"}
+
+// How long the cookie of sticky splits keeps visitors on their variant
+const stickyMaxAge = 90 * 24 * 60 * 60
+
+// Picks the variant of a visit to a link with a split, -1 when every variant is paused. Sticky splits keep the
+// visitor on the variant its cookie names while the link has it unpaused. turn returns how many turns the round robin took before
+func chooseVariant(split *DataBase.Split, turn func() int, sticky string) int {
+	total := 0
+	for i, v := range split.Variants {
+		if split.Sticky && sticky != "" && variantName(v, i) == sticky && variantWeight(v) > 0 {
+			return i
+		}
+		total += variantWeight(v)
+	}
+	if total == 0 {
+		return -1
+	}
+	var n int
+	if split.Rotation == "roundrobin" {
+		n = turn() % total
+	} else {
+		n = rand.Intn(total)
+	}
+	for i, v := range split.Variants {
+		if n -= variantWeight(v); n < 0 {
+			return i
+		}
+	}
+	return len(split.Variants) - 1
+}
+
+// The turn of a visit in the round robin of the split of record. Turns are counted in the database,
+// so concurrent visits and replicas take turns too. The hit count stands in when they can't be counted
+func nextTurn(ctx context.Context, config *Config.Config, record *DataBase.Record) int {
+	turn, err := DataBase.NextTurn(ctx, config.MongoDB, record.Mapping)
+	if err != nil {
+		Log.FromContext(ctx).Error("error counting turn", "mapping", record.Mapping, "error", err)
+		return record.HitCount
+	}
+	return turn
+}
+
+// The name clicks record for the i-th variant of a split
+func variantName(v DataBase.Variant, i int) string {
+	if v.Name != "" {
+		return v.Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+// The share of the visits of a variant, 1 when it has no weight and 0 when it's paused
+func variantWeight(v DataBase.Variant) int {
+	if v.Weight == nil {
+		return 1
+	}
+	return *v.Weight
+}
+
+// Name of the cookie that keeps the visitors of a link on their variant
+func stickyCookie(mapping string) string {
+	return "gshort_split_" + url.QueryEscape(mapping)
+}
+
+// The variant the cookie of the link names, empty when the visitor has none
+func stickyVariant(r *http.Request, mapping string) string {
+	c, err := r.Cookie(stickyCookie(mapping))
+	if err != nil {
+		return ""
+	}
+	name, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// Keeps the visitor on the variant it got, when the split of the link is sticky
+func stick(config *Config.Config, w http.ResponseWriter, record *DataBase.Record, p pick) {
+	if record.Split == nil || !record.Split.Sticky || p.Variant == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stickyCookie(record.Mapping),
+		Value:    url.QueryEscape(p.Variant),
+		Path:     "/",
+		MaxAge:   stickyMaxAge,
+		Secure:   config.Protocol == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Most variants a split can have, and the highest weight of one
+const (
+	maxVariants = 50
+	maxWeight   = 1000000
+)
+
+var errInvalidSplit = errors.New("invalid split")
+
+// Checks the split of a link: at least a variant, each with a valid URL and a weight that isn't negative,
+// one of them at least above 0, and a known rotation. Names are optional but can't repeat
+func checkSplit(split *DataBase.Split) error {
+	if split == nil {
+		return nil
+	}
+	if len(split.Variants) == 0 || len(split.Variants) > maxVariants {
+		return errInvalidSplit
+	}
+	if split.Rotation != "" && !oneOf(rotations, split.Rotation) {
+		return errInvalidSplit
+	}
+	names := make(map[string]bool)
+	total := 0
+	for i, v := range split.Variants {
+		weight := variantWeight(v)
+		if !isValidUrl(v.Url) || weight < 0 || weight > maxWeight {
+			return errInvalidSplit
+		}
+		total += weight
+		name := variantName(v, i)
+		if names[name] || len(name) > 64 {
+			return errInvalidSplit
+		}
+		names[name] = true
+	}
+	if total == 0 {
+		return errInvalidSplit
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"gShort/Config"
+	"gShort/DataBase"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func weight(n int) *int {
+	return &n
+}
+
+func variants(weights ...*int) []DataBase.Variant {
+	var vs []DataBase.Variant
+	for i, w := range weights {
+		vs = append(vs, DataBase.Variant{Name: string(rune('a' + i)), Url: "https://example.com/" + string(rune('a'+i)), Weight: w})
+	}
+	return vs
+}
+
+func TestChooseVariantWeighted(t *testing.T) {
+	tests := []struct {
+		name  string
+		split DataBase.Split
+		want  []int // visits of each variant out of 10000
+	}{
+		{"3 to 1", DataBase.Split{Variants: variants(weight(3), weight(1))}, []int{7500, 2500}},
+		{"left out weighs 1", DataBase.Split{Variants: variants(nil, weight(1), weight(2))}, []int{2500, 2500, 5000}},
+		{"paused", DataBase.Split{Variants: variants(weight(1), weight(0), weight(1))}, []int{5000, 0, 5000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rand.Seed(1)
+			got := make([]int, len(tt.want))
+			for i := 0; i < 10000; i++ {
+				got[chooseVariant(&tt.split, func() int { t.Fatal("turn of a weighted split"); return 0 }, "")]++
+			}
+			for i := range got {
+				if d := got[i] - tt.want[i]; d < -300 || d > 300 || tt.want[i] == 0 && got[i] != 0 {
+					t.Errorf("visits %v, want about %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestChooseVariantRoundRobin(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []DataBase.Variant
+		want     string // variants of the turns from 0
+	}{
+		{"by weight", variants(weight(3), weight(1)), "aaabaaab"},
+		{"left out weighs 1", variants(nil, nil, nil), "abcabcab"},
+		{"paused", variants(weight(2), weight(0), weight(1)), "aacaacaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			split := &DataBase.Split{Variants: tt.variants, Rotation: "roundrobin"}
+			var got strings.Builder
+			for turn := 0; turn < len(tt.want); turn++ {
+				got.WriteString(split.Variants[chooseVariant(split, func() int { return turn }, "")].Name)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestChooseVariantSticky(t *testing.T) {
+	tests := []struct {
+		name   string
+		split  DataBase.Split
+		cookie string
+		want   int // the variant picked, the round robin picks b
+		turned bool
+	}{
+		{"keeps its variant", DataBase.Split{Variants: variants(nil, nil), Sticky: true}, "a", 0, false},
+		{"no cookie", DataBase.Split{Variants: variants(nil, nil), Sticky: true}, "", 1, true},
+		{"variant removed", DataBase.Split{Variants: variants(nil, nil), Sticky: true}, "z", 1, true},
+		{"variant paused", DataBase.Split{Variants: variants(weight(0), nil), Sticky: true}, "a", 1, true},
+		{"not sticky", DataBase.Split{Variants: variants(nil, nil)}, "a", 1, true},
+		{"every variant paused", DataBase.Split{Variants: variants(weight(0)), Sticky: true}, "a", -1, false},
+		{"no variants", DataBase.Split{Sticky: true}, "", -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.split.Rotation = "roundrobin"
+			turned := false
+			got := chooseVariant(&tt.split, func() int { turned = true; return 1 }, tt.cookie)
+			if got != tt.want || turned != tt.turned {
+				t.Errorf("got %d, turned %v, want %d, %v", got, turned, tt.want, tt.turned)
+			}
+		})
+	}
+}
+
+// The cookie stick sets is the one the next visit reads
+func TestStick(t *testing.T) {
+	config := &Config.Config{Protocol: "https"}
+	tests := []struct {
+		name   string
+		split  *DataBase.Split
+		pick   pick
+		cookie bool
+	}{
+		{"sticky", &DataBase.Split{Variants: variants(nil, nil), Sticky: true}, pick{Variant: "b"}, true},
+		{"name to escape", &DataBase.Split{Variants: []DataBase.Variant{{Name: "b; c=d"}}, Sticky: true}, pick{Variant: "b; c=d"}, true},
+		{"not sticky", &DataBase.Split{Variants: variants(nil, nil)}, pick{Variant: "b"}, false},
+		{"picked by a rule", &DataBase.Split{Variants: variants(nil, nil), Sticky: true}, pick{Rule: "ios"}, false},
+		{"no split", nil, pick{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			stick(config, w, &DataBase.Record{Mapping: "promo code", Split: tt.split}, tt.pick)
+			cookies := w.Result().Cookies()
+			if (len(cookies) == 1) != tt.cookie {
+				t.Fatalf("cookies %v, want one: %v", cookies, tt.cookie)
+			}
+			if !tt.cookie {
+				return
+			}
+			if c := cookies[0]; !c.Secure || !c.HttpOnly || c.MaxAge != stickyMaxAge || c.Path != "/" {
+				t.Errorf("cookie %v", c)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/promo", nil)
+			r.AddCookie(cookies[0])
+			if got := stickyVariant(r, "promo code"); got != tt.pick.Variant {
+				t.Errorf("next visit has variant %q, want %q", got, tt.pick.Variant)
+			}
+			if got := stickyVariant(r, "other"); got != "" {
+				t.Errorf("another link has variant %q", got)
+			}
+		})
+	}
+}
+
+func TestCheckSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split *DataBase.Split
+		ok    bool
+	}{
+		{"none", nil, true},
+		{"weighted", &DataBase.Split{Variants: variants(weight(3), nil)}, true},
+		{"one paused", &DataBase.Split{Variants: variants(weight(0), weight(1)), Rotation: "roundrobin"}, true},
+		{"all paused", &DataBase.Split{Variants: variants(weight(0), weight(0))}, false},
+		{"negative weight", &DataBase.Split{Variants: variants(weight(-1), weight(2))}, false},
+		{"weight too high", &DataBase.Split{Variants: variants(weight(maxWeight + 1))}, false},
+		{"no variants", &DataBase.Split{}, false},
+		{"unknown rotation", &DataBase.Split{Variants: variants(nil), Rotation: "random"}, false},
+		{"javascript URL", &DataBase.Split{Variants: []DataBase.Variant{{Url: "javascript:alert(1)"}}}, false},
+		{"repeated name", &DataBase.Split{Variants: []DataBase.Variant{
+			{Name: "2", Url: "https://example.com/a"}, {Url: "https://example.com/b"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSplit(tt.split); (err == nil) != tt.ok {
+				t.Errorf("error %v, want none: %v", err, tt.ok)
+			}
+		})
+	}
+}
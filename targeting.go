@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/GeoIP"
+	"gShort/Log"
+	"gShort/UserAgent"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The visitor of a link, as its rules see it
+type visitor struct {
+	UserAgent.Client
+	Language string // the language the visitor prefers in lower case, empty when it names none
+	Country  string // ISO 3166-1 code of the client IP, empty when unknown or without geo
+	Variant  string // the variant of the link its sticky cookie names, empty without one
+}
+
+// The visitor of r to mapping. The country is the one of the client IP, behind the trusted proxies
+func newVisitor(config *Config.Config, geo *GeoIP.DB, r *http.Request, mapping string) visitor {
+	v := visitor{Client: UserAgent.Parse(r.Header.Get("User-Agent")), Variant: stickyVariant(r, mapping)}
+	if langs := acceptedLanguages(r.Header.Get("Accept-Language")); len(langs) > 0 {
+		v.Language = langs[0]
+	}
+	if geo != nil {
+		if ip := net.ParseIP(clientIP(config, r)); ip != nil {
+			var err error
+			if v.Country, err = geo.Country(ip); err != nil {
+				Log.FromContext(r.Context()).Warn("error looking up country", "error", err)
+			}
+		}
+	}
+	return v
+}
+
+// Where a visit goes before its path and query are added, see destination
+type pick struct {
+	Url     string
+	Rule    string // name of the rule that picked Url, empty for the URL of the link or its split
+	Variant string // name of the variant of the split that picked Url
+}
+
+// Picks the URL of a visit: the one of the first rule of the link that matches the visitor,
+// then the one of the country of the visitor or the geo fallback, then a variant of the split or else the link's own.
+// turn is only called by round robin splits, see chooseVariant
+func route(record *DataBase.Record, v visitor, turn func() int) pick {
+	for i, rule := range record.Rules {
+		if ruleMatches(rule, v) {
+			return pick{Url: rule.Url, Rule: ruleName(rule, i)}
+		}
+	}
+	if geo := record.Geo; geo != nil {
+		if u, ok := geo.Countries[v.Country]; ok && v.Country != "" {
+			return pick{Url: u, Rule: "geo:" + v.Country}
+		}
+		if geo.Fallback != "" {
+			return pick{Url: geo.Fallback, Rule: "geo:fallback"}
+		}
+	}
+	if split := record.Split; split != nil {
+		if i := chooseVariant(split, turn, v.Variant); i >= 0 {
+			return pick{Url: split.Variants[i].Url, Variant: variantName(split.Variants[i], i)}
+		}
+	}
+	return pick{Url: record.Url}
+}
+
+// The name clicks record for the i-th rule of a link
+func ruleName(rule DataBase.Rule, i int) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return strconv.Itoa(i + 1)
+}
+
+func ruleMatches(rule DataBase.Rule, v visitor) bool {
+	return oneOf(rule.OS, v.OS) && oneOf(rule.Device, v.Device) && oneOf(rule.Browser, v.Browser) && languageMatches(rule.Language, v.Language)
+}
+
+// Whether value is one of values, any value is when there are none
+func oneOf(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether lang is one of tags: es matches es and es-MX, es-MX only matches es-MX.
+// Any language is when there are no tags
+func languageMatches(tags []string, lang string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		tag = strings.ToLower(tag)
+		if lang == tag || strings.HasPrefix(lang, tag+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Most rules a link can have
+const maxRules = 50
+
+var errInvalidRules = errors.New("invalid rules")
+
+// Checks the rules of a link: each one has a URL, at least a condition and only values
+// UserAgent knows. Names are optional but can't repeat or be the ones of the default URL and geo rules
+func checkRules(rules []DataBase.Rule) error {
+	if len(rules) > maxRules {
+		return errInvalidRules
+	}
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if !isValidUrl(rule.Url) {
+			return errInvalidRules
+		}
+		if len(rule.OS)+len(rule.Device)+len(rule.Browser)+len(rule.Language) == 0 {
+			return errInvalidRules
+		}
+		for _, c := range []struct{ values, known []string }{
+			{rule.OS, UserAgent.OSes}, {rule.Device, UserAgent.Devices}, {rule.Browser, UserAgent.Browsers},
+		} {
+			for _, v := range c.values {
+				if !oneOf(c.known, v) {
+					return errInvalidRules
+				}
+			}
+		}
+		for _, tag := range rule.Language {
+			if tag == "" || tag == "*" || strings.ContainsAny(tag, ",; ") {
+				return errInvalidRules
+			}
+		}
+		name := ruleName(rule, i)
+		if names[name] || name == defaultRule || strings.HasPrefix(name, "geo:") || len(name) > 64 {
+			return errInvalidRules
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// Most countries the geo rules of a link can have
+const maxCountries = 250
+
+var errInvalidGeo = errors.New("invalid geo rules")
+
+// Checks the geo rules of a link: upper case country codes like ES and valid URLs
+func checkGeo(geo *DataBase.Geo) error {
+	if geo == nil {
+		return nil
+	}
+	if len(geo.Countries) > maxCountries || geo.Fallback != "" && !isValidUrl(geo.Fallback) {
+		return errInvalidGeo
+	}
+	for code, u := range geo.Countries {
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' || !isValidUrl(u) {
+			return errInvalidGeo
+		}
+	}
+	return nil
+}
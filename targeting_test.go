@@ -0,0 +1,119 @@
+package main
+
+import (
+	"gShort/Config"
+	"gShort/DataBase"
+	"gShort/UserAgent"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1"
+	android = "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	windows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0"
+)
+
+func TestRoute(t *testing.T) {
+	rules := []DataBase.Rule{
+		{Name: "ios", OS: []string{"ios"}, Url: "https://apps.apple.com/app"},
+		{OS: []string{"android"}, Device: []string{"tablet"}, Url: "https://play.google.com/tablet"},
+		{Language: []string{"es"}, Url: "https://example.com/es"},
+		{Name: "brazil", Language: []string{"pt-BR"}, Browser: []string{"firefox", "chrome"}, Url: "https://example.com/br"},
+		{Name: "late ios", OS: []string{"ios"}, Language: []string{"en"}, Url: "https://example.com/never"},
+	}
+	geo := &DataBase.Geo{Countries: map[string]string{"FR": "https://example.fr/", "DE": "https://example.de/"}}
+	withFallback := &DataBase.Geo{Countries: geo.Countries, Fallback: "https://example.com/intl"}
+	split := &DataBase.Split{Variants: variants(nil, nil), Rotation: "roundrobin"}
+
+	tests := []struct {
+		name   string
+		record DataBase.Record
+		ua     string
+		lang   string // Accept-Language
+		geo    string
+		want   pick
+	}{
+		{"first rule wins", DataBase.Record{Rules: rules}, iPhone, "en-US", "", pick{Url: "https://apps.apple.com/app", Rule: "ios"}},
+		{"rule before language", DataBase.Record{Rules: rules}, iPhone, "es", "", pick{Url: "https://apps.apple.com/app", Rule: "ios"}},
+		{"every condition", DataBase.Record{Rules: rules}, android, "de", "", pick{Url: "https://example.com/default"}},
+		{"language prefix", DataBase.Record{Rules: rules}, windows, "es-MX,en;q=0.5", "", pick{Url: "https://example.com/es", Rule: "3"}},
+		{"language exact", DataBase.Record{Rules: rules}, windows, "es", "", pick{Url: "https://example.com/es", Rule: "3"}},
+		{"language case", DataBase.Record{Rules: rules}, windows, "PT-br", "", pick{Url: "https://example.com/br", Rule: "brazil"}},
+		{"region doesn't match others", DataBase.Record{Rules: rules}, windows, "pt-PT", "", pick{Url: "https://example.com/default"}},
+		{"preferred language only", DataBase.Record{Rules: rules}, windows, "en;q=0.9,es;q=0.8", "", pick{Url: "https://example.com/default"}},
+		{"esperanto isn't spanish", DataBase.Record{Rules: rules}, windows, "eo", "", pick{Url: "https://example.com/default"}},
+		{"geo after rules", DataBase.Record{Rules: rules, Geo: geo}, iPhone, "", "FR", pick{Url: "https://apps.apple.com/app", Rule: "ios"}},
+		{"geo country", DataBase.Record{Rules: rules, Geo: geo}, windows, "", "FR", pick{Url: "https://example.fr/", Rule: "geo:FR"}},
+		{"geo other country", DataBase.Record{Geo: geo}, windows, "", "ES", pick{Url: "https://example.com/default"}},
+		{"geo fallback", DataBase.Record{Geo: withFallback}, windows, "", "ES", pick{Url: "https://example.com/intl", Rule: "geo:fallback"}},
+		{"geo unknown country", DataBase.Record{Geo: withFallback}, windows, "", "", pick{Url: "https://example.com/intl", Rule: "geo:fallback"}},
+		{"geo before split", DataBase.Record{Geo: geo, Split: split}, windows, "", "DE", pick{Url: "https://example.de/", Rule: "geo:DE"}},
+		{"split", DataBase.Record{Rules: rules, Geo: geo, Split: split}, windows, "", "ES", pick{Url: "https://example.com/b", Variant: "b"}},
+		{"split paused", DataBase.Record{Split: &DataBase.Split{Variants: variants(weight(0))}}, windows, "", "", pick{Url: "https://example.com/default"}},
+		{"default", DataBase.Record{}, "", "", "", pick{Url: "https://example.com/default"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/promo", nil)
+			r.Header.Set("User-Agent", tt.ua)
+			r.Header.Set("Accept-Language", tt.lang)
+			v := newVisitor(&Config.Config{}, nil, r, "promo")
+			v.Country = tt.geo
+			tt.record.Url = "https://example.com/default"
+			turns := 0
+			got := route(&tt.record, v, func() int { turns++; return 1 })
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			wantTurns := 0 // only a round robin pick takes a turn
+			if tt.want.Variant != "" {
+				wantTurns = 1
+			}
+			if turns != wantTurns {
+				t.Errorf("%d turns taken, want %d", turns, wantTurns)
+			}
+		})
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	url := "https://example.com/"
+	tests := []struct {
+		name  string
+		rules []DataBase.Rule
+		ok    bool
+	}{
+		{"none", nil, true},
+		{"conditions", []DataBase.Rule{
+			{OS: []string{"ios", "android"}, Url: url},
+			{Device: []string{"tablet"}, Browser: []string{"safari"}, Url: url},
+			{Name: "spanish", Language: []string{"es", "pt-BR"}, Url: url},
+		}, true},
+		{"every known value", []DataBase.Rule{
+			{OS: UserAgent.OSes, Device: UserAgent.Devices, Browser: UserAgent.Browsers, Url: url},
+		}, true},
+		{"no condition", []DataBase.Rule{{Url: url}}, false},
+		{"no URL", []DataBase.Rule{{OS: []string{"ios"}}}, false},
+		{"javascript URL", []DataBase.Rule{{OS: []string{"ios"}, Url: "javascript:alert(1)"}}, false},
+		{"unknown OS", []DataBase.Rule{{OS: []string{"iOS"}, Url: url}}, false},
+		{"unknown device", []DataBase.Rule{{Device: []string{"phone"}, Url: url}}, false},
+		{"unknown browser", []DataBase.Rule{{Browser: []string{"netscape"}, Url: url}}, false},
+		{"any language", []DataBase.Rule{{Language: []string{"*"}, Url: url}}, false},
+		{"empty language", []DataBase.Rule{{Language: []string{""}, Url: url}}, false},
+		{"language list", []DataBase.Rule{{Language: []string{"es,en"}, Url: url}}, false},
+		{"repeated name", []DataBase.Rule{{Name: "a", OS: []string{"ios"}, Url: url}, {Name: "a", OS: []string{"android"}, Url: url}}, false},
+		{"name of a position", []DataBase.Rule{{Name: "2", OS: []string{"ios"}, Url: url}, {OS: []string{"android"}, Url: url}}, false},
+		{"default name", []DataBase.Rule{{Name: defaultRule, OS: []string{"ios"}, Url: url}}, false},
+		{"geo name", []DataBase.Rule{{Name: "geo:ES", OS: []string{"ios"}, Url: url}}, false},
+		{"too many", make([]DataBase.Rule, maxRules+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRules(tt.rules); (err == nil) != tt.ok {
+				t.Errorf("error %v, want none: %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"gShort/Config"
+	"gShort/Log"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	rice "github.com/GeertJohan/go.rice"
+)
+
+// Pages rendered through html/template, every other file of a theme is served as is
+const (
+	pageIndex    = "index.html"
+	pagePassword = "password.html"
+	pageNotFound = "404.html"
+	pageExpired  = "expired.html"
+	pageDisabled = "disabled.html"
+	pageRedirect = "redirect.html"
+)
+
+var pages = []string{pageIndex, pagePassword, pageNotFound, pageExpired, pageDisabled, pageRedirect}
+
+// The data every page is rendered with, themes can rely on all of it
+type page struct {
+	SiteName  string
+	TagLine   string
+	Domain    string
+	Protocol  string
+	Port      int    // the port users reach gShort on
+	BaseURL   string // eg: https://short.example, without a trailing slash
+	Lang      string // language of the catalog the page is rendered with
+	Nonce     string // CSP nonce of the request, inline scripts need nonce="{{ .Nonce }}"
+	ReCaptcha struct {
+		SiteKey string // empty when reCAPTCHA is off
+	}
+	Slug string // the short link requested, set on the password, 404, expired, disabled and redirect pages. The password page gets its query too
+
+	// Set on the redirect page, which links with the refresh and interstitial redirects get
+	Target       string // the URL the link goes to
+	Interstitial bool   // false when the page redirects by itself with a meta refresh
+
+	// Set on the pages rendered after a form post
+	URL    string // the URL sent to the shorten form
+	Short  string // the short URL created by the shorten form
+	QRCode string // URL of the PNG QR code of Short, empty when QR codes are off
+	Error  string // why the form was rejected, already translated
+
+	theme *theme
+}
+
+// The data of a page of site for r, in the language r prefers.
+// r can be nil to use the default language of the site
+func (t *theme) page(site *Config.Config, r *http.Request) page {
+	p := page{
+		SiteName: site.SiteName,
+		TagLine:  site.TagLine,
+		Domain:   site.Domain,
+		Protocol: site.Protocol,
+		Port:     site.PublicPort(),
+		BaseURL:  strings.TrimSuffix(canonicalURL(site, "/"), "/"),
+		theme:    t,
+	}
+	p.ReCaptcha.SiteKey = site.ReCaptcha.SiteKey
+	acceptLanguage := ""
+	if r != nil {
+		acceptLanguage = r.Header.Get("Accept-Language")
+		p.Nonce = nonceFrom(r.Context())
+	}
+	p.Lang = t.negotiate(acceptLanguage, site.Language)
+	return p
+}
+
+// Translates a message id to the language of the page, eg: {{ .T "shorten" }}
+func (p page) T(id string, args ...interface{}) string {
+	return p.theme.translate(p.Lang, id, args...)
+}
+
+// The files of the site, the ones in dir take precedence over the ones built into gShort
+type theme struct {
+	dir       string // empty when only the built in files are used
+	box       *rice.Box
+	templates map[string]*template.Template
+	catalogs  map[string]catalog // by language
+}
+
+// Loads a theme and parses its pages, a page that doesn't parse is an error
+func loadTheme(dir string) (*theme, error) {
+	box, err := rice.FindBox("website")
+	if err != nil {
+		return nil, err
+	}
+	t := &theme{dir: dir, box: box, templates: make(map[string]*template.Template)}
+	for _, name := range pages {
+		f, err := t.open(name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.templates[name], err = template.New(name).Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := t.loadCatalogs(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Opens a file of the theme, name is relative to the root of the theme
+func (t *theme) open(name string) (http.File, error) {
+	if t.dir != "" {
+		f, err := http.Dir(t.dir).Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return t.box.HTTPBox().Open(name)
+}
+
+// Implements http.FileSystem for the files served as is, pages and directories are left out
+func (t *theme) Open(name string) (http.File, error) {
+	if !t.has(name) {
+		return nil, os.ErrNotExist
+	}
+	return t.open(name)
+}
+
+// Whether the theme has a file to serve as is for the URL path p
+func (t *theme) has(p string) bool {
+	p = path.Clean("/" + p)
+	for _, name := range pages {
+		if p == "/"+name {
+			return false
+		}
+	}
+	f, err := t.open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	return err == nil && !info.IsDir()
+}
+
+// Renders a page
+func (t *theme) execute(name string, data page) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.templates[name].Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Renders a page as the response, rendering errors are logged and answered with a 500
+func (t *theme) render(w http.ResponseWriter, r *http.Request, name string, status int, data page) {
+	b, err := t.execute(name, data)
+	if err != nil {
+		Log.FromContext(r.Context()).Error("error rendering page", "page", name, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if len(t.catalogs) > 1 {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"gShort/Config"
+	"gShort/Log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type loadedCert struct {
+	certFile, keyFile string
+	modTime           time.Time // newest modification time of both files
+	cert              *tls.Certificate
+}
+
+// Serves the configured certificates and reloads them when their files change on disk
+type certReloader struct {
+	mu    sync.RWMutex
+	certs []*loadedCert
+}
+
+func newCertReloader(config *Config.TLS) (*certReloader, error) {
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("TLS is enabled but no Certificates are configured")
+	}
+	c := &certReloader{}
+	for _, cert := range config.Certificates {
+		l := &loadedCert{certFile: cert.CertFile, keyFile: cert.KeyFile}
+		if err := l.load(); err != nil {
+			return nil, err
+		}
+		c.certs = append(c.certs, l)
+	}
+	return c, nil
+}
+
+func (l *loadedCert) load() error {
+	modTime, err := l.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	l.cert = &cert
+	l.modTime = modTime
+	return nil
+}
+
+func (l *loadedCert) lastModified() (t time.Time, err error) {
+	for _, file := range []string{l.certFile, l.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return t, err
+		}
+		if info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	return
+}
+
+// Checks the certificate files every interval and reloads the ones that changed.
+// A certificate that fails to load is logged and the previous one is kept
+func (c *certReloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		c.mu.RLock()
+		certs := c.certs
+		c.mu.RUnlock()
+
+		for i, l := range certs {
+			modTime, err := l.lastModified()
+			if err != nil || !modTime.After(l.modTime) {
+				continue
+			}
+			reloaded := &loadedCert{certFile: l.certFile, keyFile: l.keyFile}
+			if err := reloaded.load(); err != nil {
+				Log.Error("error reloading certificate", "file", l.certFile, "error", err)
+				continue
+			}
+			c.mu.Lock()
+			c.certs[i] = reloaded
+			c.mu.Unlock()
+			Log.Info("reloaded certificate", "file", l.certFile)
+		}
+	}
+}
+
+// Picks the certificate for the server name sent by the client (SNI),
+// the first one is used when none matches
+func (c *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if hello.ServerName != "" {
+		for _, l := range c.certs {
+			if l.cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return l.cert, nil
+			}
+		}
+	}
+	return c.certs[0].cert, nil
+}
+
+// Redirects every request to the HTTPS URL of the domain it was made for
+func redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site, _ := siteFor(live().config, r)
+		http.Redirect(w, r, canonicalURL(site, r.RequestURI), http.StatusMovedPermanently)
+	})
+}
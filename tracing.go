@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"gShort/Log"
+	"gShort/Tracing"
+	"net/http"
+)
+
+// Wraps every handler in a server span, continuing the trace of the W3C traceparent
+// header when there is one. Log entries of the request get the trace ID
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r)
+		ctx, span := Tracing.Start(Tracing.Extract(r.Context(), r), r.Method+" "+route, Tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.Path)
+		span.SetAttribute("http.host", r.Host)
+		if id := Tracing.TraceID(ctx); id != "" {
+			ctx = Log.NewContext(ctx, Log.FromContext(ctx).With("trace_id", id))
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", recorder.status)
+		if recorder.status >= 500 {
+			span.SetError(errors.New(http.StatusText(recorder.status)))
+		}
+	})
+}
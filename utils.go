@@ -1,55 +1,64 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"gShort/Config"
 	"gShort/DataBase"
-	rice "github.com/GeertJohan/go.rice"
-	"log"
 	"math/rand"
 	"net/http"
 	"net/url"
-	"strconv"
-	"text/template"
+	"strings"
 	"time"
 )
 
 // Increases the hitcount of a mapping and deletes the mapping if maxhit is reached
-func hitCounter(config *Config.Config, mapping string) (err error) {
-	record, err := DataBase.IncreaseHitCount(config.MongoDB, mapping)
+func hitCounter(ctx context.Context, config *Config.Config, mapping string) (err error) {
+	record, err := DataBase.IncreaseHitCount(ctx, config.MongoDB, mapping)
 	if err != nil {
-		log.Printf("Error while increasing hitcount: %v", err)
+		return
 	}
-	if record.MaxHitCount > 0 {
-		if record.HitCount >= record.MaxHitCount {
-			err = record.Delete(config.MongoDB)
-			if err != nil {
-				log.Printf("Error while deleting record: %v", err)
-			}
-		}
+	if record.MaxHitCount > 0 && record.HitCount >= record.MaxHitCount {
+		err = record.Delete(ctx, config.MongoDB)
 	}
 	return
 }
 
-// Checks whether a box has a specific file
-func boxHasFile(box *rice.Box, file string) bool {
-	if file == "/" {
-		return true
-	}
-	oo, err := box.Open(file)
-	if err != nil {
-		return false
+// The query of r with its ?, empty when it has none. The password page keeps it for the link
+func withQuery(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return ""
 	}
-	oo.Close()
-	return true
+	return "?" + r.URL.RawQuery
+}
+
+// How long the writes made after a visit was redirected have, see detached
+const detachedTimeout = 10 * time.Second
+
+// A context for the writes made once the response of a visit was written. It keeps the logger
+// and span of ctx but not its cancellation, so a client that goes away doesn't stop them
+func detached(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(valuesOnly{ctx}, detachedTimeout)
+}
+
+// A context with the values of another one, without its deadline or cancellation
+type valuesOnly struct {
+	parent context.Context
 }
 
+func (valuesOnly) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (valuesOnly) Done() <-chan struct{}               { return nil }
+func (valuesOnly) Err() error                          { return nil }
+func (c valuesOnly) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// Whether links can point to rawurl: an absolute http or https URL. Other schemes, like javascript: and data:,
+// would run in the page of the short domain through the refresh and interstitial pages
 func isValidUrl(rawurl string) bool {
-	_, err := url.ParseRequestURI(rawurl)
+	u, err := url.ParseRequestURI(rawurl)
 	if err != nil {
 		return false
 	}
-	return true
+	scheme := strings.ToLower(u.Scheme)
+	return (scheme == "http" || scheme == "https") && u.Host != ""
 }
 
 // Generates a random string with the given length and charset
@@ -62,61 +71,99 @@ func generateStringWithCharset(length int, charset string) string {
 	return string(b)
 }
 
-func trimLeftChar(s string) string {
-	for i := range s {
-		if i > 0 {
-			return s[i:]
+// Paths served by gShort itself, they can't be used as aliases
+var reservedSlugs = []string{"short", "password", "api", "healthz", "readyz", "metrics", "assets", "images"}
+
+// Whether s can be used as the mapping of a link: letters, digits, - and _, up to 64 of them
+func validAlias(s string) bool {
+	if len(s) == 0 || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	for _, reserved := range reservedSlugs {
+		if strings.EqualFold(s, reserved) {
+			return false
 		}
 	}
-	return s[:0]
+	return true
 }
 
-func buildIndex(config *Config.Config) (index string, err error) {
-	box, err := rice.FindBox("website")
-	if err != nil {
-		return
+// Splits the path of a visit into the slug and what follows it, eg: /docs/some/page is docs and /some/page
+func splitSlug(p string) (slug string, rest string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i:]
 	}
-	tmpl, err := box.String("index.html")
-	if err != nil {
-		return
+	return p, ""
+}
+
+// The URL a visit to a link goes to, from the base URL picked by route. Prefix links get the escaped path
+// after the slug appended to the path of base. ok is false for other links when the visit has a path after the slug.
+// Parameters are added to the query of base without replacing any: the ones already in base
+// come first, then the UTM parameters of the link and then the query of the visit, when forwarded
+func destination(config *Config.Config, record *DataBase.Record, base string, rest string, query string) (target string, ok bool) {
+	if rest != "" && !record.Prefix {
+		return base, false
 	}
-	var indexTemplate *template.Template
-	indexTemplate, err = template.New("index").Parse(tmpl)
-	if err != nil {
-		return
+	if !record.ForwardsQuery(config.ForwardQuery) {
+		query = ""
+	}
+	if rest == "" && query == "" && record.UTM == nil {
+		return base, true
 	}
-	var tpl bytes.Buffer
-	err = indexTemplate.Execute(&tpl, config)
+	u, err := url.Parse(base)
 	if err != nil {
-		return
+		return base, rest == ""
+	}
+	if rest != "" {
+		escaped := strings.TrimSuffix(u.EscapedPath(), "/") + rest
+		if u.Path, err = url.PathUnescape(escaped); err != nil {
+			return base, false
+		}
+		u.RawPath = escaped
 	}
-	index = tpl.String()
-	return
-}
-
-// Prevent returning stuff like http://localhost/XXXX when port != 80
-func buildMapping(config *Config.Config, mapping string) string {
-	if config.Protocol == "http" && config.Port != 80 {
-		mapping = "http://" + config.Domain + ":" + strconv.Itoa(config.Port) + "/" + mapping
-	} else if config.Protocol == "https" && config.Port != 443 {
-		mapping = "https://" + config.Domain + ":" + strconv.Itoa(config.Port) + "/" + mapping
-	} else {
-		mapping = config.Protocol + "://" + config.Domain + "/" + mapping
-	}
-	return mapping
-}
 
-// This function check if the host header matches the provided domain:port
-// For requests on port 80 or 443 it won't check the port
-func comingFromDomain(domain string, port int, r *http.Request) bool {
-	if port != 80 && port != 443 {
-		if r.Host == domain+":"+strconv.Itoa(port) {
-			return true
+	present := u.Query()
+	var added []string // pairs are kept as they came, only their keys are compared
+	if utm := record.UTM; utm != nil {
+		for _, p := range [][2]string{{"utm_source", utm.Source}, {"utm_medium", utm.Medium}, {"utm_campaign", utm.Campaign}} {
+			if _, ok := present[p[0]]; ok || p[1] == "" {
+				continue
+			}
+			present[p[0]] = []string{p[1]}
+			added = append(added, p[0]+"="+url.QueryEscape(p[1]))
+		}
+	}
+	fromVisit := make(map[string]bool) // keys can repeat in the visit's query
+	for _, pair := range strings.Split(query, "&") {
+		if pair == "" {
+			continue
+		}
+		key := strings.SplitN(pair, "=", 2)[0]
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
 		}
-	} else {
-		if r.Host == domain {
-			return true
+		if _, ok := present[key]; ok && !fromVisit[key] {
+			continue
 		}
+		present[key], fromVisit[key] = nil, true
+		added = append(added, pair)
 	}
-	return false
+
+	if len(added) > 0 {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += strings.Join(added, "&")
+	}
+	return u.String(), true
+}
+
+// Returns the short URL of a mapping
+func buildMapping(config *Config.Config, mapping string) string {
+	return canonicalURL(config, "/"+mapping)
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"gShort/Config"
+	"gShort/DataBase"
+	"testing"
+)
+
+func TestDestination(t *testing.T) {
+	yes, no := true, false
+	utm := &DataBase.UTM{Source: "newsletter", Medium: "email"}
+	tests := []struct {
+		name   string
+		record DataBase.Record
+		base   string
+		rest   string
+		query  string
+		global bool // Config.ForwardQuery
+		want   string
+		ok     bool
+	}{
+		{"plain", DataBase.Record{}, "https://example.com/a?x=1", "", "", false, "https://example.com/a?x=1", true},
+		{"query not forwarded", DataBase.Record{}, "https://example.com/a", "", "ref=7", false, "https://example.com/a", true},
+		{"query forwarded", DataBase.Record{}, "https://example.com/a", "", "ref=7", true, "https://example.com/a?ref=7", true},
+		{"link opts out", DataBase.Record{ForwardQuery: &no}, "https://example.com/a", "", "ref=7", true, "https://example.com/a", true},
+		{"link opts in", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a", "", "ref=7", false, "https://example.com/a?ref=7", true},
+		{"base key wins over visit", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a?ref=1", "", "ref=7&b=2", false,
+			"https://example.com/a?ref=1&b=2", true},
+		{"base key wins over escaped visit key", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a?a%20b=1", "", "a+b=7&a%20b=8", false,
+			"https://example.com/a?a%20b=1", true},
+		{"repeated visit keys kept", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a", "", "t=1&t=2&u", false,
+			"https://example.com/a?t=1&t=2&u", true},
+		{"visit pairs kept as they came", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a", "", "q=a+b%26c&&=x", false,
+			"https://example.com/a?q=a+b%26c&=x", true},
+		{"utm added", DataBase.Record{UTM: utm}, "https://example.com/a?x=1", "", "", false,
+			"https://example.com/a?x=1&utm_source=newsletter&utm_medium=email", true},
+		{"base utm wins", DataBase.Record{UTM: utm}, "https://example.com/a?utm_source=ads", "", "", false,
+			"https://example.com/a?utm_source=ads&utm_medium=email", true},
+		{"link utm wins over visit", DataBase.Record{UTM: utm, ForwardQuery: &yes}, "https://example.com/a", "", "utm_source=ads&utm_campaign=spring&ref=7", false,
+			"https://example.com/a?utm_source=newsletter&utm_medium=email&utm_campaign=spring&ref=7", true},
+		{"utm values escaped", DataBase.Record{UTM: &DataBase.UTM{Campaign: "spring sale&more"}}, "https://example.com/", "", "", false,
+			"https://example.com/?utm_campaign=spring+sale%26more", true},
+		{"fragment kept", DataBase.Record{ForwardQuery: &yes}, "https://example.com/a#top", "", "ref=7", false,
+			"https://example.com/a?ref=7#top", true},
+		{"prefix path", DataBase.Record{Prefix: true}, "https://docs.example.com/", "/some/page", "tab=2", false,
+			"https://docs.example.com/some/page?tab=2", true},
+		{"prefix path without slash", DataBase.Record{Prefix: true}, "https://docs.example.com/v1", "/some/page", "", false,
+			"https://docs.example.com/v1/some/page", true},
+		{"prefix escaped path", DataBase.Record{Prefix: true}, "https://docs.example.com/", "/a%2Fb/c%20d", "", false,
+			"https://docs.example.com/a%2Fb/c%20d", true},
+		{"prefix query clash", DataBase.Record{Prefix: true}, "https://docs.example.com/?lang=en", "/p", "lang=es&tab=2", false,
+			"https://docs.example.com/p?lang=en&tab=2", true},
+		{"prefix opts out of query", DataBase.Record{Prefix: true, ForwardQuery: &no}, "https://docs.example.com/", "/p", "tab=2", false,
+			"https://docs.example.com/p", true},
+		{"prefix malformed path", DataBase.Record{Prefix: true}, "https://docs.example.com/", "/%zz", "", false, "https://docs.example.com/", false},
+		{"path after plain link", DataBase.Record{}, "https://example.com/a", "/more", "", false, "https://example.com/a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config.Config{ForwardQuery: tt.global}
+			got, ok := destination(config, &tt.record, tt.base, tt.rest, tt.query)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %s, %v, want %s, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsValidUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/", true},
+		{"http://example.com", true},
+		{"HTTPS://example.com/a?b=1#c", true},
+		{"https://127.0.0.1:8080/", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(document.domain)//https://example.com/", false},
+		{"data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==", false},
+		{"vbscript:msgbox(1)", false},
+		{"ftp://example.com/file", false},
+		{"mailto:someone@example.com", false},
+		{"https:///path", false},
+		{"//example.com/", false},
+		{"/relative", false},
+		{"example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := isValidUrl(tt.url); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}